@@ -0,0 +1,71 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/carlosbrando/furby/parse"
+)
+
+// astMain implements the ast command:
+//
+//	furby ast [--json] file
+//
+// It prints the parse tree of file, one node per line indented by depth,
+// or as JSON with --json.
+func astMain(args []string) {
+	flags := flag.NewFlagSet("ast", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the tree as JSON")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: furby ast [--json] file")
+		os.Exit(2)
+	}
+	name := flags.Arg(0)
+
+	code, err := ioutil.ReadFile(name)
+	check(err)
+	treeSet, err := parse.Parse(name, string(code))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	root := treeSet[name].Root
+
+	if *asJSON {
+		data, err := json.MarshalIndent(root, "", "  ")
+		check(err)
+		fmt.Printf("%s\n", data)
+		return
+	}
+
+	depth := 0
+	parse.Inspect(root, func(n parse.Node) bool {
+		if n == nil {
+			depth--
+			return false
+		}
+		fmt.Printf("%s%T %d", strings.Repeat("  ", depth), n, n.Position())
+		switch n := n.(type) {
+		case *parse.ListNode, *parse.ActionNode, *parse.CommandNode, *parse.CaseNode, *parse.WhenNode, *parse.InNode:
+			fmt.Println()
+		case *parse.DefNode:
+			fmt.Printf(" %q\n", n.Name)
+		case *parse.ForNode:
+			fmt.Printf(" %q\n", n.Var)
+		default:
+			fmt.Printf(" %q\n", n)
+		}
+		depth++
+		return true
+	})
+}
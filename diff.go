@@ -0,0 +1,153 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around a change.
+const diffContext = 3
+
+// diff returns the differences between the lines of b1 and b2 in unified
+// format, as diff -u prints them, or nil if there are none. name1 and
+// name2 label the two versions.
+func diff(name1, name2 string, b1, b2 []byte) []byte {
+	ops := editScript(splitLines(b1), splitLines(b2))
+
+	// line1[i] and line2[i] count the lines of each version before ops[i].
+	line1 := make([]int, len(ops)+1)
+	line2 := make([]int, len(ops)+1)
+	for i, op := range ops {
+		line1[i+1], line2[i+1] = line1[i], line2[i]
+		if op[0] != '+' {
+			line1[i+1]++
+		}
+		if op[0] != '-' {
+			line2[i+1]++
+		}
+	}
+
+	var b bytes.Buffer
+	for i := 0; i < len(ops); i++ {
+		if ops[i][0] == ' ' {
+			continue
+		}
+		if b.Len() == 0 {
+			fmt.Fprintf(&b, "--- %s\n+++ %s\n", name1, name2)
+		}
+		// A hunk runs from a change to the last change that is less than
+		// two contexts of unchanged lines away, plus the context around.
+		start, end := i-diffContext, i+1
+		if start < 0 {
+			start = 0
+		}
+		for j := end; j < len(ops) && j <= end+2*diffContext; j++ {
+			if ops[j][0] != ' ' {
+				end = j + 1
+			}
+		}
+		i = end - 1
+		if end += diffContext; end > len(ops) {
+			end = len(ops)
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n",
+			hunkRange(line1[start], line1[end]), hunkRange(line2[start], line2[end]))
+		for _, op := range ops[start:end] {
+			b.WriteString(op)
+			if !strings.HasSuffix(op, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	return b.Bytes()
+}
+
+// hunkRange formats the lines from after line first up to line last for
+// a hunk header. An empty range is given by the line before it.
+func hunkRange(first, last int) string {
+	if first == last {
+		return fmt.Sprintf("%d,0", first)
+	}
+	return fmt.Sprintf("%d,%d", first+1, last-first)
+}
+
+// splitLines splits b after each newline. The last line may lack one.
+func splitLines(b []byte) []string {
+	lines := strings.SplitAfter(string(b), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// editScript returns the shortest list of operations that turns the
+// lines a into the lines b, found with Myers' O(ND) algorithm. Each
+// operation is a line prefixed with ' ' if it is kept, '-' if it is
+// deleted or '+' if it is inserted.
+func editScript(a, b []string) []string {
+	n, m := len(a), len(b)
+	offset := n + m + 1 // v is indexed by diagonal k = x - y, from -offset.
+	v := make([]int, 2*offset+1)
+	// trace[d] holds diagonals -d-1 to d+1 of v before step d, the ones
+	// the step reads, to walk the path back.
+	var trace [][]int
+Search:
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			x := v[offset+k-1] + 1 // a deletion from diagonal k-1
+			if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+				x = v[offset+k+1] // an insertion from diagonal k+1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break Search
+			}
+		}
+	}
+
+	var ops []string
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d] // v[d+1+k] is diagonal k
+		k := x - y
+		prev := k - 1
+		if k == -d || k != d && v[d+k] < v[d+k+2] {
+			prev = k + 1
+		}
+		prevX := v[d+1+prev]
+		prevY := prevX - prev
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			ops = append(ops, " "+a[x])
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, "+"+b[y])
+		} else {
+			x--
+			ops = append(ops, "-"+a[x])
+		}
+	}
+	for x > 0 {
+		x--
+		ops = append(ops, " "+a[x])
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
@@ -0,0 +1,111 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/carlosbrando/furby/format"
+)
+
+var exitCode = 0
+
+func report(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	exitCode = 2
+}
+
+// fmtMain implements the fmt command:
+//
+//	furby fmt [-w] [-d] [path ...]
+//
+// With no paths it formats the standard input. Directories are walked
+// and every .frb file found is formatted.
+func fmtMain(args []string) {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write result to (source) file instead of stdout")
+	doDiff := flags.Bool("d", false, "display diffs instead of rewriting files")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		if *write {
+			report(fmt.Errorf("error: cannot use -w with standard input"))
+			return
+		}
+		if err := processFile("<standard input>", os.Stdin, os.Stdout, false, *doDiff); err != nil {
+			report(err)
+		}
+		return
+	}
+
+	for _, path := range flags.Args() {
+		switch dir, err := os.Stat(path); {
+		case err != nil:
+			report(err)
+		case dir.IsDir():
+			filepath.Walk(path, func(path string, f os.FileInfo, err error) error {
+				if err == nil && !f.IsDir() && strings.HasSuffix(f.Name(), ".frb") {
+					err = processFile(path, nil, os.Stdout, *write, *doDiff)
+				}
+				if err != nil {
+					report(err)
+				}
+				return nil
+			})
+		default:
+			if err := processFile(path, nil, os.Stdout, *write, *doDiff); err != nil {
+				report(err)
+			}
+		}
+	}
+}
+
+// processFile formats the named file, reading it from in when in is not nil,
+// and writes the result to out, back to the file or as a diff.
+func processFile(filename string, in io.Reader, out io.Writer, write, doDiff bool) error {
+	if in == nil {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	res, err := format.Source(filename, src)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(src, res) {
+		if write {
+			if err = ioutil.WriteFile(filename, res, 0644); err != nil {
+				return err
+			}
+		}
+		if doDiff {
+			fmt.Fprintf(out, "diff %s furby/%s\n", filename, filename)
+			out.Write(diff(filename+".orig", filename, src, res))
+		}
+	}
+
+	if !write && !doDiff {
+		_, err = out.Write(res)
+	}
+
+	return err
+}
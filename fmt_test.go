@@ -0,0 +1,121 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	unformatted = "x=a||b\nputs 'hi'\n"
+	formatted   = "x = a || b\nputs \"hi\"\n"
+)
+
+// tempFile writes src to a new .frb file and returns its name.
+func tempFile(t *testing.T, src string) string {
+	dir, err := ioutil.TempDir("", "furby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "test.frb")
+	if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestProcessFileWrite(t *testing.T) {
+	name := tempFile(t, unformatted)
+	defer os.RemoveAll(filepath.Dir(name))
+
+	var out bytes.Buffer
+	if err := processFile(name, nil, &out, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("-w printed %q", out.String())
+	}
+	got, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != formatted {
+		t.Errorf("-w wrote %q, want %q", got, formatted)
+	}
+}
+
+func TestProcessFileDiff(t *testing.T) {
+	name := tempFile(t, unformatted)
+	defer os.RemoveAll(filepath.Dir(name))
+
+	var out bytes.Buffer
+	if err := processFile(name, nil, &out, false, true); err != nil {
+		t.Fatal(err)
+	}
+	want := "diff " + name + " furby/" + name + "\n" +
+		"--- " + name + ".orig\n" +
+		"+++ " + name + "\n" +
+		"@@ -1,2 +1,2 @@\n" +
+		"-x=a||b\n" +
+		"-puts 'hi'\n" +
+		"+x = a || b\n" +
+		"+puts \"hi\"\n"
+	if out.String() != want {
+		t.Errorf("-d printed\n%s\nwant\n%s", out.String(), want)
+	}
+	if got, err := ioutil.ReadFile(name); err != nil || string(got) != unformatted {
+		t.Errorf("-d changed the file to %q (%v)", got, err)
+	}
+
+	// A formatted file has no diff.
+	out.Reset()
+	if err := processFile("formatted.frb", bytes.NewBufferString(formatted), &out, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("-d printed %q for a formatted file", out.String())
+	}
+}
+
+var diffTests = []struct {
+	name   string
+	b1, b2 string
+	diff   string
+}{
+	{"equal", "a\nb\n", "a\nb\n", ""},
+	{"empty", "", "", ""},
+	{"insert", "", "a\n", "@@ -0,0 +1,1 @@\n+a\n"},
+	{"delete", "a\nb\n", "b\n", "@@ -1,2 +1,1 @@\n-a\n b\n"},
+	{"no newline", "a", "a\n", "@@ -1,1 +1,1 @@\n-a\n\\ No newline at end of file\n+a\n"},
+	{
+		"context",
+		"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n",
+		"1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\nsixteen\n",
+		"@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n" +
+			"@@ -13,4 +13,4 @@\n 13\n 14\n 15\n-16\n+sixteen\n",
+	},
+	{
+		"joined hunks",
+		"1\n2\n3\n4\n5\n6\n7\n8\n",
+		"one\n2\n3\n4\n5\n6\n7\neight\n",
+		"@@ -1,8 +1,8 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n",
+	},
+}
+
+func TestDiff(t *testing.T) {
+	for _, test := range diffTests {
+		want := test.diff
+		if want != "" {
+			want = "--- old\n+++ new\n" + want
+		}
+		if got := diff("old", "new", []byte(test.b1), []byte(test.b2)); string(got) != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, want)
+		}
+	}
+}
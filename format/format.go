@@ -0,0 +1,29 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+// Package format implements standard formatting of Furby source.
+package format
+
+import "github.com/carlosbrando/furby/parse"
+
+// Source formats src in canonical Furby style and returns the result
+// or a syntax error. src is expected to be a complete source file;
+// name is only used in error messages.
+//
+// The canonical style is the one printed by the parse tree's String
+// method, ending in a newline. Bodies are indented with tabs, binary
+// operators have a space on each side and :: none, strings are in double
+// quotes and comments are kept where they were.
+func Source(name string, src []byte) ([]byte, error) {
+	treeSet, err := parse.Parse(name, string(src))
+	if err != nil {
+		return nil, err
+	}
+
+	res := treeSet[name].Root.String()
+	if res != "" {
+		res += "\n"
+	}
+	return []byte(res), nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package format
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// For every testdata/file.input, testdata/file.golden holds what Source
+// makes of it.
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no .input files in testdata")
+	}
+
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Source(file, src)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		golden := strings.TrimSuffix(file, ".input") + ".golden"
+		if *update {
+			if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Errorf("%s (run go test -update to create it)", err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: got\n%s\nwant\n%s", file, got, want)
+		}
+	}
+}
+
+// TestIdempotent checks that formatting formatted source changes nothing,
+// for the goldens and for the parser's corpus.
+func TestIdempotent(t *testing.T) {
+	goldens, err := filepath.Glob("testdata/*.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	corpus, err := filepath.Glob("../testdata/*.frb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range append(goldens, corpus...) {
+		if _, err := os.Stat(strings.TrimSuffix(file, ".frb") + ".err"); err == nil {
+			continue // a file the parser rejects
+		}
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		once, err := Source(file, src)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		twice, err := Source(file, once)
+		if err != nil {
+			t.Errorf("%s: formatted source does not parse: %s", file, err)
+			continue
+		}
+		if !bytes.Equal(once, twice) {
+			t.Errorf("%s: formatting is not idempotent:\n%s\nthen\n%s", file, once, twice)
+		}
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	if _, err := Source("bad.frb", []byte("x = a ==\n")); err == nil {
+		t.Error("Source accepted a syntax error")
+	}
+}
@@ -5,10 +5,13 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
 
 	"github.com/carlosbrando/furby/lexer"
+	"github.com/carlosbrando/furby/lsp"
 	"github.com/carlosbrando/furby/parse"
 )
 
@@ -19,6 +22,22 @@ func check(e error) {
 }
 
 func main() {
+	flag.Parse()
+	switch flag.Arg(0) {
+	case "ast":
+		astMain(flag.Args()[1:])
+		return
+	case "fmt":
+		fmtMain(flag.Args()[1:])
+		os.Exit(exitCode)
+	case "lsp":
+		if err := lsp.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// read code
 	code, err := ioutil.ReadFile("hello.frb")
 	check(err)
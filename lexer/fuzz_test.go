@@ -0,0 +1,65 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package lexer
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fuzzTimeout bounds a single Tokenize call so that a loop that makes
+// no progress is reported instead of stalling the fuzzer.
+const fuzzTimeout = 2 * time.Second
+
+// addSeeds seeds f with the example programs and the golden corpus.
+func addSeeds(f *testing.F) {
+	for _, pattern := range []string{"../*.frb", "../testdata/*.frb"} {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, file := range files {
+			src, err := ioutil.ReadFile(file)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(string(src))
+		}
+	}
+}
+
+func FuzzTokenize(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, code string) {
+		done := make(chan [][]string, 1)
+		go func() { done <- Tokenize(code) }()
+
+		var tokens [][]string
+		select {
+		case tokens = <-done:
+		case <-time.After(fuzzTimeout):
+			t.Fatalf("Tokenize(%q) did not finish in %v", code, fuzzTimeout)
+		}
+
+		// Every token value is a piece of the input, in order.
+		rest := code
+		for _, token := range tokens {
+			if len(token) != 2 || token[0] == "" {
+				t.Fatalf("Tokenize(%q): malformed token %q", code, token)
+			}
+			if token[0] == "ERROR" {
+				break
+			}
+			i := strings.Index(rest, token[1])
+			if i < 0 {
+				t.Fatalf("Tokenize(%q): token %q not found in remaining input %q", code, token, rest)
+			}
+			rest = rest[i+len(token[1]):]
+		}
+	})
+}
@@ -2,81 +2,55 @@
 // Use of this source code is governed by a MIT license
 // that can be found in the LICENSE file.
 
+// Package lexer keeps the original Tokenize API on top of package scanner,
+// which is what the parser uses.
 package lexer
 
-import (
-	"regexp"
-	"strings"
-)
-
-type Token struct {
-	tokenType string
-	value     string
-	length    int
-	matched   bool
-}
-
-var whitespaceRegex = regexp.MustCompile(`\A\s+`)
-
+import "github.com/carlosbrando/furby/scanner"
+
+// Tokenize returns the tokens of code in the form [TOKEN_TYPE, value].
+//
+// Spaces, line breaks and comments are left out. Keywords are tagged with
+// their own name, 'if' will result in an [IF, if] token, and operators and
+// other single characters with their value, e.g. [==, ==] or [(, (].
+// Strings are given without their quotes, with their escapes as written.
+// The other tokens are tagged with the name of their scanner.Kind, such
+// as [SYMBOL, :name] or [REGEXP, /x/i].
+//
+// The tokens are the scanner's, so they differ from those of the original
+// regular expression lexer where the scanner knows more of the language:
+//
+// - Numbers follow the scanner's grammar: -7, 3.25 and 0x1F are one token
+// and 12abc is an error.
+// - Identifiers and constants may contain any Unicode letter.
+// - Comments are skipped instead of tokenized word by word.
+// - Symbols, labels, regular expressions and complex numbers are tokens of
+// their own, and the keywords added since, such as else and case, are
+// tagged as keywords.
+// - The long operators include ===, =~, !~, =>, .., ... and **.
+// - A string may contain an escaped quote and must close on its line.
+// - If the scanner finds an error, an [ERROR, message] token ends the
+// list. The original lexer never failed.
 func Tokenize(code string) [][]string {
-	// cleanup code by remove extra line breaks
-	code = strings.TrimSpace(code)
-
-	// collection of all parsed tokens in the form [:TOKEN_TYPE, value]
+	// collection of all parsed tokens in the form [TOKEN_TYPE, value]
 	var tokens [][]string
 
-	// We keep track of the indentation levels we are in so that when we dedent, we can
-	// check if we're on the correct level.
-	// indent_stack := []string
-
-	// scan one character at the time until you find something to parse.
-	for i := 0; i < len(code); {
-		chunk := code[i:]
-
-		// Matching standard tokens.
-		//
-		token := findIdentifier(chunk)
-
-		if !token.matched {
-			token = findConstant(chunk)
-		}
-
-		if !token.matched {
-			token = findNumber(chunk)
-		}
-
-		if !token.matched {
-			token = findString(chunk)
-		}
-
-		// ...
-
-		if !token.matched {
-			token = findOperator(chunk)
-		}
-
-		if !token.matched {
-			if m := whitespaceRegex.FindString(chunk); m != "" {
-				i += len(m)
-				continue // ignore whitespace
-			}
-		}
-
-		// catch all single characters
-		// we treat all other single characters as a token. Eg.: ( ) , . ! + - <
-		if !token.matched {
-			value := chunk[:1]
-			token = Token{value, value, 1, true}
-		}
-
-		// if a token was found add it to the stack
-		if token.matched {
-			tokens = append(tokens, []string{token.tokenType, token.value})
-			i += token.length
-		} else {
-			i += 1
+	s := scanner.New("", code)
+	for {
+		token := s.NextToken()
+		switch token.Kind {
+		case scanner.EOF:
+			return tokens
+		case scanner.Error:
+			return append(tokens, []string{token.Kind.String(), token.Val})
+		case scanner.Space, scanner.EndOfLine, scanner.Comment:
+			// ignore whitespace
+		case scanner.Char, scanner.Operator:
+			tokens = append(tokens, []string{token.Val, token.Val})
+		case scanner.String:
+			tokens = append(tokens, []string{token.Kind.String(), token.Val[1 : len(token.Val)-1]})
+		default:
+			tokens = append(tokens, []string{token.Kind.String(), token.Val})
 		}
 	}
-
-	return tokens
 }
@@ -0,0 +1,48 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package lexer
+
+import (
+	"reflect"
+	"testing"
+)
+
+var tokenizeTests = []struct {
+	code   string
+	tokens [][]string
+}{
+	{"", nil},
+	{"puts \"Hello\" # greet\n", [][]string{{"IDENTIFIER", "puts"}, {"STRING", "Hello"}}},
+	{"def f(a)\n\tnil\nend", [][]string{
+		{"DEF", "def"}, {"IDENTIFIER", "f"}, {"(", "("}, {"IDENTIFIER", "a"}, {")", ")"},
+		{"NIL", "nil"}, {"END", "end"},
+	}},
+	{"module Net\nend", [][]string{{"MODULE", "module"}, {"CONSTANT", "Net"}, {"END", "end"}}},
+	{"Net::Http::Get", [][]string{{"CONSTANT", "Net"}, {"::", "::"}, {"CONSTANT", "Http"}, {"::", "::"}, {"CONSTANT", "Get"}}},
+	{"a : :b", [][]string{{"IDENTIFIER", "a"}, {":", ":"}, {"SYMBOL", ":b"}}},
+	{"x = -7 3.25 0x1F 1+2i", [][]string{
+		{"IDENTIFIER", "x"}, {"=", "="}, {"NUMBER", "-7"}, {"NUMBER", "3.25"}, {"NUMBER", "0x1F"}, {"COMPLEX", "1+2i"},
+	}},
+	{`'it\'s' "a\"b"`, [][]string{{"STRING", `it\'s`}, {"STRING", `a\"b`}}},
+	{"case x when /a/i then f(key: 1) else y end", [][]string{
+		{"CASE", "case"}, {"IDENTIFIER", "x"}, {"WHEN", "when"}, {"REGEXP", "/a/i"}, {"THEN", "then"},
+		{"IDENTIFIER", "f"}, {"(", "("}, {"LABEL", "key:"}, {"NUMBER", "1"}, {")", ")"},
+		{"ELSE", "else"}, {"IDENTIFIER", "y"}, {"END", "end"},
+	}},
+	{"a === b .. c ** d", [][]string{
+		{"IDENTIFIER", "a"}, {"===", "==="}, {"IDENTIFIER", "b"}, {"..", ".."}, {"IDENTIFIER", "c"}, {"**", "**"}, {"IDENTIFIER", "d"},
+	}},
+	{"Über héllo", [][]string{{"CONSTANT", "Über"}, {"IDENTIFIER", "héllo"}}},
+	{"puts 12abc", [][]string{{"IDENTIFIER", "puts"}, {"ERROR", `bad number syntax: "12a"`}}},
+	{"puts \"open\nclose\"", [][]string{{"IDENTIFIER", "puts"}, {"ERROR", "unterminated quoted string"}}},
+}
+
+func TestTokenize(t *testing.T) {
+	for _, test := range tokenizeTests {
+		if got := Tokenize(test.code); !reflect.DeepEqual(got, test.tokens) {
+			t.Errorf("Tokenize(%q) = %q, want %q", test.code, got, test.tokens)
+		}
+	}
+}
@@ -0,0 +1,433 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+// Package lsp implements a Language Server Protocol server for Furby
+// source files, speaking JSON-RPC over a pair of streams such as stdio.
+//
+// The server keeps the full text of every open document, reparses it on
+// each change and publishes the parser's error as a diagnostic. While the
+// text parses, it finds method definitions, shows their doc comments on
+// hover, lists them as document symbols and completes the names in scope.
+// While it doesn't, those requests have empty results: the positions of
+// an older tree would not match the text the client has.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/carlosbrando/furby/parse"
+)
+
+// Server is a language server reading requests from in and writing
+// responses and notifications to out.
+type Server struct {
+	in       *bufio.Reader
+	out      io.Writer
+	docs     map[string]*document // open documents by URI
+	shutdown bool                 // a shutdown request was received
+}
+
+// document is an open text document.
+type document struct {
+	text string
+	tree *parse.Tree // the tree of text, or nil if it has errors
+}
+
+// NewServer returns a server reading from in and writing to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: make(map[string]*document),
+	}
+}
+
+// Serve handles messages until the client sends exit or closes the
+// input. It returns an error if the client exits without asking for a
+// shutdown first, as the protocol requires.
+func (s *Server) Serve() error {
+	for {
+		data, err := readMessage(s.in)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		var msg message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.replyError(nil, codeParseError, err.Error())
+			continue
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("lsp: exit without shutdown")
+			}
+			return nil
+		}
+
+		if err := s.handle(&msg); err != nil {
+			return err
+		}
+	}
+}
+
+// handle dispatches a single request or notification.
+func (s *Server) handle(msg *message) error {
+	if s.shutdown && msg.ID != nil {
+		return s.replyError(msg.ID, codeInvalidRequest, "server is shutting down")
+	}
+
+	switch msg.Method {
+	case "initialize":
+		return s.reply(msg.ID, map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1, // full document sync
+				"completionProvider":     map[string]interface{}{},
+				"definitionProvider":     true,
+				"hoverProvider":          true,
+				"documentSymbolProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "furby"},
+		})
+	case "initialized":
+		return nil
+	case "shutdown":
+		s.shutdown = true
+		return s.reply(msg.ID, nil)
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil
+		}
+		return s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		// With full sync the last change holds the whole document.
+		text := params.ContentChanges[len(params.ContentChanges)-1].Text
+		return s.update(params.TextDocument.URI, text)
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return s.publish(params.TextDocument.URI, []Diagnostic{})
+	case "textDocument/completion", "textDocument/definition", "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return s.replyError(msg.ID, codeInvalidParams, err.Error())
+		}
+		doc := s.docs[params.TextDocument.URI]
+		if doc == nil || doc.tree == nil {
+			if msg.Method == "textDocument/completion" {
+				return s.reply(msg.ID, []CompletionItem{})
+			}
+			return s.reply(msg.ID, nil)
+		}
+		off := offset(doc.text, params.Position)
+		switch msg.Method {
+		case "textDocument/completion":
+			return s.reply(msg.ID, doc.completion(off))
+		case "textDocument/definition":
+			if loc := doc.definition(off); loc != nil {
+				loc.URI = params.TextDocument.URI
+				return s.reply(msg.ID, loc)
+			}
+		case "textDocument/hover":
+			if hover := doc.hover(off); hover != nil {
+				return s.reply(msg.ID, hover)
+			}
+		}
+		return s.reply(msg.ID, nil)
+	case "textDocument/documentSymbol":
+		var params documentSymbolParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return s.replyError(msg.ID, codeInvalidParams, err.Error())
+		}
+		symbols := []DocumentSymbol{}
+		if doc := s.docs[params.TextDocument.URI]; doc != nil && doc.tree != nil {
+			symbols = doc.symbols(doc.tree.Root)
+		}
+		return s.reply(msg.ID, symbols)
+	}
+
+	if msg.ID != nil {
+		return s.replyError(msg.ID, codeMethodNotFound, "method not found: "+msg.Method)
+	}
+	// Unknown notifications are ignored.
+	return nil
+}
+
+// update stores the new text of a document, reparses it and publishes
+// the resulting diagnostics.
+func (s *Server) update(uri, text string) error {
+	doc := s.docs[uri]
+	if doc == nil {
+		doc = new(document)
+		s.docs[uri] = doc
+	}
+	doc.text = text
+
+	diagnostics := []Diagnostic{}
+	treeSet, err := parse.Parse(uri, text)
+	doc.tree = treeSet[uri]
+	if err != nil {
+		diagnostics = append(diagnostics, diagnostic(text, err))
+	}
+	return s.publish(uri, diagnostics)
+}
+
+// diagnostic converts a parse error into a diagnostic spanning from the
+// offending token to the end of its line.
+func diagnostic(text string, err error) Diagnostic {
+	d := Diagnostic{Severity: severityError, Source: "furby", Message: err.Error()}
+	if e, ok := err.(*parse.Error); ok {
+		d.Message = e.Msg
+		start := int(e.Pos)
+		if start < 0 {
+			start = 0
+		} else if start > len(text) {
+			start = len(text)
+		}
+		end := strings.IndexAny(text[start:], "\r\n")
+		if end < 0 {
+			end = len(text)
+		} else {
+			end += start
+		}
+		d.Range = Range{position(text, start), position(text, end)}
+	}
+	return d
+}
+
+// completion returns the names in scope at offset: the methods defined
+// or called in the document, the parameters of the enclosing definition
+// and the variables bound before offset in the same scope.
+func (doc *document) completion(offset int) []CompletionItem {
+	items := []CompletionItem{}
+	seen := make(map[string]bool)
+	add := func(name string, kind int) {
+		if !seen[name] {
+			seen[name] = true
+			items = append(items, CompletionItem{Label: name, Kind: kind})
+		}
+	}
+	addVariable := func(name string) { add(name, completionKindVariable) }
+
+	root := doc.tree.Root
+	var scope parse.Node = root
+	if def := enclosingDef(root, position(doc.text, offset).Line+1); def != nil {
+		scope = def
+		for _, p := range def.Params {
+			addVariable(p.Name)
+		}
+	}
+	variables(scope, offset, addVariable)
+
+	// The variables of every scope, to tell method calls from them.
+	vars := make(map[string]bool)
+	addVar := func(name string) { vars[name] = true }
+	variables(root, len(doc.text), addVar)
+	parse.Inspect(root, func(n parse.Node) bool {
+		if def, ok := n.(*parse.DefNode); ok {
+			variables(def, len(doc.text), addVar)
+			for _, p := range def.Params {
+				addVar(p.Name)
+			}
+		}
+		return n != nil
+	})
+	parse.Inspect(root, func(n parse.Node) bool {
+		switch n := n.(type) {
+		case *parse.DefNode:
+			add(n.Name, completionKindFunction)
+		case *parse.CommandNode:
+			// A command starting with a name that is not a variable
+			// anywhere in the document calls a method.
+			if ident, ok := n.Args[0].(*parse.IdentifierNode); ok && !vars[ident.Ident] {
+				add(ident.Ident, completionKindFunction)
+			}
+		case *parse.CallNode:
+			if ident, ok := n.Func.(*parse.IdentifierNode); ok {
+				add(ident.Ident, completionKindFunction)
+			}
+		}
+		return n != nil
+	})
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
+
+// definition returns the location of the definition of the method
+// named at offset, without its URI, or nil if there is none.
+func (doc *document) definition(offset int) *Location {
+	def := defs(doc.tree.Root)[identifierAt(doc.text, doc.tree.Root, offset)]
+	if def == nil {
+		return nil
+	}
+	return &Location{Range: doc.nameRange(def)}
+}
+
+// hover returns the signature and doc comment of the method named at
+// offset, or nil if it is not a method defined in the document.
+func (doc *document) hover(offset int) *Hover {
+	def := defs(doc.tree.Root)[identifierAt(doc.text, doc.tree.Root, offset)]
+	if def == nil {
+		return nil
+	}
+	value := "```furby\n" + signature(def) + "\n```"
+	if comment := docComment(doc.text, doc.tree.Root, def); comment != "" {
+		value += "\n\n" + comment
+	}
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: value}}
+}
+
+// symbols returns the methods defined in n, outside the definitions in
+// it, with the methods defined inside each one as its children.
+func (doc *document) symbols(n parse.Node) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	parse.Inspect(n, func(m parse.Node) bool {
+		def, ok := m.(*parse.DefNode)
+		if !ok || def == n {
+			return m != nil
+		}
+		end := offset(doc.text, Position{Line: def.EndLine - 1, Character: len(doc.text)})
+		symbols = append(symbols, DocumentSymbol{
+			Name:           def.Name,
+			Detail:         signature(def),
+			Kind:           symbolKindMethod,
+			Range:          Range{position(doc.text, int(def.Pos)), position(doc.text, end)},
+			SelectionRange: doc.nameRange(def),
+			Children:       doc.symbols(def),
+		})
+		return false
+	})
+	return symbols
+}
+
+// nameRange returns the range of the name in a definition.
+func (doc *document) nameRange(def *parse.DefNode) Range {
+	start := nameOffset(doc.text, def)
+	return Range{position(doc.text, start), position(doc.text, start+len(def.Name))}
+}
+
+func (s *Server) publish(uri string, diagnostics []Diagnostic) error {
+	return s.write(notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  publishDiagnosticsParams{URI: uri, Diagnostics: diagnostics},
+	})
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}) error {
+	return s.write(response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (s *Server) replyError(id *json.RawMessage, code int, message string) error {
+	return s.write(errorResponse{JSONRPC: "2.0", ID: id, Error: responseError{code, message}})
+}
+
+// write sends v to the client framed by a Content-Length header.
+func (s *Server) write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+	_, err = s.out.Write(data)
+	return err
+}
+
+// readMessage reads the headers and body of the next message.
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line != "" {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if i := strings.IndexByte(line, ':'); i >= 0 && strings.EqualFold(line[:i], "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(line[i+1:])); err != nil {
+				return nil, fmt.Errorf("lsp: bad Content-Length: %s", line[i+1:])
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("lsp: missing Content-Length header")
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// position converts a byte offset in text to an LSP position, whose
+// character offsets are counted in UTF-16 code units.
+func position(text string, offset int) Position {
+	var p Position
+	for _, r := range text[:offset] {
+		switch {
+		case r == '\n':
+			p.Line++
+			p.Character = 0
+		case r >= 0x10000:
+			p.Character += 2 // a surrogate pair
+		default:
+			p.Character++
+		}
+	}
+	return p
+}
+
+// offset converts an LSP position to a byte offset in text. A position
+// past the end of its line is taken as the end of the line and one past
+// the last line as the end of the text.
+func offset(text string, p Position) int {
+	i := 0
+	for line := 0; line < p.Line; line++ {
+		n := strings.IndexByte(text[i:], '\n')
+		if n < 0 {
+			return len(text)
+		}
+		i += n + 1
+	}
+	for char := 0; char < p.Character && i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if r == '\n' || r == '\r' {
+			break
+		}
+		if r >= 0x10000 {
+			char += 2 // a surrogate pair
+		} else {
+			char++
+		}
+		i += size
+	}
+	return i
+}
@@ -0,0 +1,436 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/carlosbrando/furby/parse"
+)
+
+// client is a minimal JSON-RPC client talking to a Server through pipes.
+type client struct {
+	t      *testing.T
+	w      io.WriteCloser
+	r      *bufio.Reader
+	nextID int
+	done   chan error
+}
+
+func newClient(t *testing.T) *client {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &client{t: t, w: inW, r: bufio.NewReader(outR), done: make(chan error, 1)}
+	go func() {
+		err := NewServer(inR, outW).Serve()
+		outW.Close()
+		c.done <- err
+	}()
+	return c
+}
+
+func (c *client) send(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// recv reads the next message from the server into a generic map.
+func (c *client) recv() map[string]interface{} {
+	data, err := readMessage(c.r)
+	if err != nil {
+		c.t.Fatalf("reading message: %s", err)
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+// call sends a request and returns the response to it.
+func (c *client) call(method string, params interface{}) map[string]interface{} {
+	c.nextID++
+	c.send(map[string]interface{}{"jsonrpc": "2.0", "id": c.nextID, "method": method, "params": params})
+	msg := c.recv()
+	if id, _ := msg["id"].(float64); int(id) != c.nextID {
+		c.t.Fatalf("%s: response has id %v, want %d", method, msg["id"], c.nextID)
+	}
+	return msg
+}
+
+func (c *client) notify(method string, params interface{}) {
+	c.send(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+// diagnostics reads a publishDiagnostics notification for uri.
+func (c *client) diagnostics(uri string) []interface{} {
+	msg := c.recv()
+	if msg["method"] != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("got %v, want publishDiagnostics", msg)
+	}
+	params := msg["params"].(map[string]interface{})
+	if params["uri"] != uri {
+		c.t.Fatalf("diagnostics for %v, want %s", params["uri"], uri)
+	}
+	return params["diagnostics"].([]interface{})
+}
+
+func (c *client) exit() error {
+	c.notify("exit", nil)
+	c.w.Close()
+	return <-c.done
+}
+
+const uri = "file:///hello.frb"
+
+func TestSession(t *testing.T) {
+	c := newClient(t)
+
+	resp := c.call("initialize", map[string]interface{}{})
+	caps := resp["result"].(map[string]interface{})["capabilities"].(map[string]interface{})
+	if caps["textDocumentSync"] != 1.0 {
+		t.Errorf("textDocumentSync = %v, want 1", caps["textDocumentSync"])
+	}
+	c.notify("initialized", map[string]interface{}{})
+
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "furby", "version": 1, "text": "puts 2\nputs 3\n"},
+	})
+	if d := c.diagnostics(uri); len(d) != 0 {
+		t.Errorf("clean document has diagnostics: %v", d)
+	}
+
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+		"contentChanges": []interface{}{map[string]interface{}{"text": "puts 2\nputs 12abc\n"}},
+	})
+	d := c.diagnostics(uri)
+	if len(d) != 1 {
+		t.Fatalf("got %d diagnostics, want 1", len(d))
+	}
+	want := map[string]interface{}{
+		"start": map[string]interface{}{"line": 1.0, "character": 5.0},
+		"end":   map[string]interface{}{"line": 1.0, "character": 10.0},
+	}
+	if got := d[0].(map[string]interface{})["range"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("diagnostic range = %v, want %v", got, want)
+	}
+
+	// There is nothing to complete from while the text has errors.
+	resp = c.call("textDocument/completion", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+		"position":     map[string]interface{}{"line": 1, "character": 0},
+	})
+	if items := resp["result"].([]interface{}); len(items) != 0 {
+		t.Errorf("completion = %v, want []", items)
+	}
+
+	resp = c.call("textDocument/rename", map[string]interface{}{})
+	if code := resp["error"].(map[string]interface{})["code"]; code != float64(codeMethodNotFound) {
+		t.Errorf("unknown method error code = %v, want %d", code, codeMethodNotFound)
+	}
+
+	c.notify("textDocument/didClose", map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri}})
+	if d := c.diagnostics(uri); len(d) != 0 {
+		t.Errorf("closing did not clear diagnostics: %v", d)
+	}
+
+	resp = c.call("shutdown", nil)
+	if result, ok := resp["result"]; !ok || result != nil {
+		t.Errorf("shutdown result = %v, want null", resp)
+	}
+	if err := c.exit(); err != nil {
+		t.Errorf("exit after shutdown: %s", err)
+	}
+}
+
+// open opens a document with the given text and checks that it parses.
+func (c *client) open(text string) {
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "furby", "version": 1, "text": text},
+	})
+	if d := c.diagnostics(uri); len(d) != 0 {
+		c.t.Fatalf("document has diagnostics: %v", d)
+	}
+}
+
+// change replaces the text of the document and returns its diagnostics.
+func (c *client) change(text string) []interface{} {
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+		"contentChanges": []interface{}{map[string]interface{}{"text": text}},
+	})
+	return c.diagnostics(uri)
+}
+
+// at calls method with the position of line and character in the
+// document and returns the result.
+func (c *client) at(method string, line, character int) interface{} {
+	resp := c.call(method, map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+		"position":     map[string]interface{}{"line": line, "character": character},
+	})
+	if err, ok := resp["error"]; ok {
+		c.t.Fatalf("%s: %v", method, err)
+	}
+	return resp["result"]
+}
+
+func (c *client) shutdown() {
+	c.call("shutdown", nil)
+	if err := c.exit(); err != nil {
+		c.t.Error(err)
+	}
+}
+
+// jsonValue returns v as the client decodes it.
+func jsonValue(t *testing.T, v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func rng(startLine, startChar, endLine, endChar int) Range {
+	return Range{Position{startLine, startChar}, Position{endLine, endChar}}
+}
+
+const methods = `# Greets someone.
+# Twice.
+def greet(name, greeting = "hi")
+  puts greeting name
+end
+
+x = 1 # not a doc comment
+def twice(n)
+  y = n
+  def inner
+  end
+  puts
+end
+greet x
+`
+
+func TestDefinition(t *testing.T) {
+	c := newClient(t)
+	c.call("initialize", map[string]interface{}{})
+	c.open(methods)
+
+	want := jsonValue(t, Location{URI: uri, Range: rng(2, 4, 2, 9)})
+	for _, pos := range []Position{{13, 0}, {13, 3}, {13, 5}, {2, 6}} {
+		if got := c.at("textDocument/definition", pos.Line, pos.Character); !reflect.DeepEqual(got, want) {
+			t.Errorf("definition at %v = %v, want %v", pos, got, want)
+		}
+	}
+	// Variables and methods defined elsewhere have no definition here.
+	for _, pos := range []Position{{13, 6}, {3, 3}, {5, 0}} {
+		if got := c.at("textDocument/definition", pos.Line, pos.Character); got != nil {
+			t.Errorf("definition at %v = %v, want null", pos, got)
+		}
+	}
+	c.shutdown()
+}
+
+func TestHover(t *testing.T) {
+	c := newClient(t)
+	c.call("initialize", map[string]interface{}{})
+	c.open(methods)
+
+	tests := []struct {
+		line, character int
+		value           string
+	}{
+		{13, 2, "```furby\ndef greet(name, greeting = \"hi\")\n```\n\nGreets someone.\nTwice."},
+		// A comment ending a statement is not a doc comment.
+		{7, 5, "```furby\ndef twice(n)\n```"},
+	}
+	for _, test := range tests {
+		want := jsonValue(t, Hover{MarkupContent{"markdown", test.value}})
+		if got := c.at("textDocument/hover", test.line, test.character); !reflect.DeepEqual(got, want) {
+			t.Errorf("hover at %d:%d = %v, want %v", test.line, test.character, got, want)
+		}
+	}
+	if got := c.at("textDocument/hover", 13, 6); got != nil {
+		t.Errorf("hover on a variable = %v, want null", got)
+	}
+	c.shutdown()
+}
+
+func TestDocumentSymbol(t *testing.T) {
+	c := newClient(t)
+	c.call("initialize", map[string]interface{}{})
+	c.open(methods)
+
+	resp := c.call("textDocument/documentSymbol", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+	})
+	want := jsonValue(t, []DocumentSymbol{
+		{
+			Name: "greet", Detail: `def greet(name, greeting = "hi")`, Kind: symbolKindMethod,
+			Range: rng(2, 0, 4, 3), SelectionRange: rng(2, 4, 2, 9), Children: []DocumentSymbol{},
+		},
+		{
+			Name: "twice", Detail: "def twice(n)", Kind: symbolKindMethod,
+			Range: rng(7, 0, 12, 3), SelectionRange: rng(7, 4, 7, 9),
+			Children: []DocumentSymbol{{
+				Name: "inner", Detail: "def inner", Kind: symbolKindMethod,
+				Range: rng(9, 2, 10, 5), SelectionRange: rng(9, 6, 9, 11), Children: []DocumentSymbol{},
+			}},
+		},
+	})
+	if got := resp["result"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("document symbols =\n%v\nwant\n%v", got, want)
+	}
+	c.shutdown()
+}
+
+func TestCompletionScope(t *testing.T) {
+	c := newClient(t)
+	c.call("initialize", map[string]interface{}{})
+	c.open(methods)
+
+	const f, v = completionKindFunction, completionKindVariable
+	tests := []struct {
+		line, character int
+		items           []CompletionItem
+	}{
+		// In twice, before y is assigned.
+		{8, 2, []CompletionItem{{"greet", f}, {"inner", f}, {"n", v}, {"puts", f}, {"twice", f}}},
+		// In twice, after y is assigned.
+		{11, 2, []CompletionItem{{"greet", f}, {"inner", f}, {"n", v}, {"puts", f}, {"twice", f}, {"y", v}}},
+		// In greet.
+		{3, 2, []CompletionItem{{"greet", f}, {"greeting", v}, {"inner", f}, {"name", v}, {"puts", f}, {"twice", f}}},
+		// At the top level, after x is assigned.
+		{13, 6, []CompletionItem{{"greet", f}, {"inner", f}, {"puts", f}, {"twice", f}, {"x", v}}},
+	}
+	for _, test := range tests {
+		want := jsonValue(t, test.items)
+		if got := c.at("textDocument/completion", test.line, test.character); !reflect.DeepEqual(got, want) {
+			t.Errorf("completion at %d:%d = %v, want %v", test.line, test.character, got, want)
+		}
+	}
+	c.shutdown()
+}
+
+func TestCompletionCall(t *testing.T) {
+	c := newClient(t)
+	c.call("initialize", map[string]interface{}{})
+	c.open("def log(a, level: 1)\nend\nx = 1\nputs log(warn(x), level: 2)\n")
+
+	const f = completionKindFunction
+	want := jsonValue(t, []CompletionItem{{"log", f}, {"puts", f}, {"warn", f}, {"x", completionKindVariable}})
+	if got := c.at("textDocument/completion", 3, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("completion = %v, want %v", got, want)
+	}
+	// The name of a call leads to its definition.
+	want = jsonValue(t, Location{URI: uri, Range: rng(0, 4, 0, 7)})
+	if got := c.at("textDocument/definition", 3, 6); !reflect.DeepEqual(got, want) {
+		t.Errorf("definition = %v, want %v", got, want)
+	}
+	c.shutdown()
+}
+
+func TestInvalidText(t *testing.T) {
+	c := newClient(t)
+	c.call("initialize", map[string]interface{}{})
+	c.open("def f\nend\nf\n")
+
+	// The definition moved down three lines, and the text doesn't parse.
+	if d := c.change("\n\n\ndef f\nend\nf\n("); len(d) != 1 {
+		t.Fatalf("got %d diagnostics, want 1", len(d))
+	}
+	symbols := c.call("textDocument/documentSymbol", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+	})["result"]
+	if want := jsonValue(t, []DocumentSymbol{}); !reflect.DeepEqual(symbols, want) {
+		t.Errorf("symbols = %v, want %v", symbols, want)
+	}
+	for _, method := range []string{"textDocument/definition", "textDocument/hover"} {
+		if got := c.at(method, 5, 0); got != nil {
+			t.Errorf("%s = %v, want null", method, got)
+		}
+	}
+	if got, want := c.at("textDocument/completion", 5, 0), jsonValue(t, []CompletionItem{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("completion = %v, want %v", got, want)
+	}
+
+	// Once it parses again, the answers are about the new text.
+	if d := c.change("\n\n\ndef f\nend\nf\n"); len(d) != 0 {
+		t.Fatalf("document has diagnostics: %v", d)
+	}
+	want := jsonValue(t, Location{URI: uri, Range: rng(3, 4, 3, 5)})
+	if got := c.at("textDocument/definition", 5, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("definition = %v, want %v", got, want)
+	}
+	c.shutdown()
+}
+
+func TestDiagnosticPastEnd(t *testing.T) {
+	// An error from stale text may point past the end of the document.
+	d := diagnostic("puts 2", &parse.Error{Name: "x", Line: 3, Pos: 40, Msg: "unexpected EOF"})
+	if want := rng(0, 6, 0, 6); d.Range != want {
+		t.Errorf("range = %v, want %v", d.Range, want)
+	}
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	c := newClient(t)
+	if err := c.exit(); err == nil {
+		t.Error("exit without shutdown returned no error")
+	}
+}
+
+func TestPosition(t *testing.T) {
+	tests := []struct {
+		text   string
+		offset int
+		want   Position
+	}{
+		{"puts 2", 5, Position{0, 5}},
+		{"puts 2\nputs 3", 12, Position{1, 5}},
+		{"é 1", 3, Position{0, 2}},
+		{"\U0001F600 1", 5, Position{0, 3}},
+	}
+	for _, test := range tests {
+		if got := position(test.text, test.offset); got != test.want {
+			t.Errorf("position(%q, %d) = %v, want %v", test.text, test.offset, got, test.want)
+		}
+	}
+}
+
+func TestOffset(t *testing.T) {
+	tests := []struct {
+		text string
+		pos  Position
+		want int
+	}{
+		{"puts 2", Position{0, 5}, 5},
+		{"puts 2\nputs 3", Position{1, 5}, 12},
+		{"é 1", Position{0, 2}, 3},
+		{"\U0001F600 1", Position{0, 3}, 5},
+		{"puts 2\r\nx", Position{0, 10}, 6}, // past the end of the line
+		{"puts 2\nx", Position{5, 0}, 8},    // past the last line
+	}
+	for _, test := range tests {
+		if got := offset(test.text, test.pos); got != test.want {
+			t.Errorf("offset(%q, %v) = %d, want %d", test.text, test.pos, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+// Queries on parse trees for the language features.
+
+package lsp
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/carlosbrando/furby/parse"
+)
+
+// defs returns the method definitions in the tree by name. A method
+// defined twice is found at its last definition, which is the one that
+// counts.
+func defs(root parse.Node) map[string]*parse.DefNode {
+	m := make(map[string]*parse.DefNode)
+	parse.Inspect(root, func(n parse.Node) bool {
+		if def, ok := n.(*parse.DefNode); ok {
+			m[def.Name] = def
+		}
+		return n != nil
+	})
+	return m
+}
+
+// nameOffset returns the offset in text of the name of a definition,
+// which follows the def keyword.
+func nameOffset(text string, def *parse.DefNode) int {
+	i := int(def.Pos) + len("def")
+	for i < len(text) && (text[i] == ' ' || text[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+// identifierAt returns the name of the identifier at offset in text, or
+// of the method whose definition names it there, or "" if there is none.
+func identifierAt(text string, root parse.Node, offset int) string {
+	name := ""
+	parse.Inspect(root, func(n parse.Node) bool {
+		switch n := n.(type) {
+		case *parse.IdentifierNode:
+			if int(n.Pos) <= offset && offset <= int(n.Pos)+len(n.Ident) {
+				name = n.Ident
+			}
+		case *parse.DefNode:
+			if start := nameOffset(text, n); start <= offset && offset <= start+len(n.Name) {
+				name = n.Name
+			}
+		}
+		return name == "" && n != nil
+	})
+	return name
+}
+
+// docComment returns the text of the comments on the lines right above
+// a definition, without their # markers, or "" if there are none. A
+// comment that ends the line of a statement doesn't document the next
+// one.
+func docComment(text string, root parse.Node, def *parse.DefNode) string {
+	var doc []string
+	parse.Inspect(root, func(n parse.Node) bool {
+		list, ok := n.(*parse.ListNode)
+		if !ok {
+			return n != nil
+		}
+		for i, node := range list.Nodes {
+			if node != def {
+				continue
+			}
+			line := def.Line
+			for j := i - 1; j >= 0; j-- {
+				c, ok := list.Nodes[j].(*parse.CommentNode)
+				if !ok || c.Line != line-1 || !startsLine(text, int(c.Pos)) {
+					break
+				}
+				doc = append([]string{strings.TrimPrefix(strings.TrimPrefix(c.Text, "#"), " ")}, doc...)
+				line = c.Line
+			}
+			return false
+		}
+		return true
+	})
+	return strings.Join(doc, "\n")
+}
+
+// startsLine reports whether only spaces come before offset on its line.
+func startsLine(text string, offset int) bool {
+	start := strings.LastIndexAny(text[:offset], "\r\n") + 1
+	return strings.TrimLeft(text[start:offset], " \t") == ""
+}
+
+// signature returns the header of a definition, as in def f(a, b = 2).
+func signature(def *parse.DefNode) string {
+	s := "def " + def.Name
+	if len(def.Params) > 0 {
+		params := make([]string, len(def.Params))
+		for i, p := range def.Params {
+			params[i] = p.String()
+		}
+		s += "(" + strings.Join(params, ", ") + ")"
+	}
+	return s
+}
+
+// enclosingDef returns the innermost definition whose lines include
+// line, or nil if line is at the top level.
+func enclosingDef(root parse.Node, line int) *parse.DefNode {
+	var def *parse.DefNode
+	parse.Inspect(root, func(n parse.Node) bool {
+		if d, ok := n.(*parse.DefNode); ok {
+			if line < d.Line || line > d.EndLine {
+				return false
+			}
+			def = d
+		}
+		return n != nil
+	})
+	return def
+}
+
+// variables calls add with the local variables that are bound in scope
+// before offset: the targets of assignments, the variables of for loops
+// and the names bound by the patterns of in clauses. Definitions in
+// scope have their own variables and are left out.
+func variables(scope parse.Node, offset int, add func(name string)) {
+	parse.Inspect(scope, func(n parse.Node) bool {
+		if n == nil {
+			return false
+		}
+		if n != scope && n.Type() == parse.NodeDef || int(n.Position()) >= offset {
+			return false
+		}
+		switch n := n.(type) {
+		case *parse.AssignNode:
+			for _, target := range n.Targets {
+				bound(target, add)
+			}
+		case *parse.ForNode:
+			add(n.Var)
+		case *parse.InNode:
+			bound(n.Pattern, add)
+		}
+		return true
+	})
+}
+
+// bound calls add with the names that an assignment target or a pattern
+// binds. Constants in a pattern are matched, not bound.
+func bound(n parse.Node, add func(name string)) {
+	parse.Inspect(n, func(n parse.Node) bool {
+		switch n := n.(type) {
+		case *parse.IdentifierNode:
+			if r := []rune(n.Ident)[0]; !unicode.IsUpper(r) {
+				add(n.Ident)
+			}
+		case *parse.BindNode:
+			add(n.Name)
+		case *parse.HashPatternNode:
+			for i, key := range n.Keys {
+				if n.Patterns[i] == nil {
+					add(key)
+				}
+			}
+		case *parse.RangeNode, *parse.ScopeNode:
+			return false // values to compare with
+		}
+		return n != nil
+	})
+}
@@ -0,0 +1,59 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package parse
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// addSeeds seeds f with the example programs and the golden corpus.
+func addSeeds(f *testing.F) {
+	for _, pattern := range []string{"../*.frb", "../testdata/*.frb"} {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, file := range files {
+			src, err := ioutil.ReadFile(file)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(string(src))
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, src string) {
+		done := make(chan error, 1)
+		go func() {
+			_, err := Parse("fuzz", src)
+			done <- err
+		}()
+
+		var err error
+		select {
+		case err = <-done:
+		case <-time.After(parseTimeout):
+			t.Fatalf("parsing %q did not finish in %v", src, parseTimeout)
+		}
+
+		if err != nil {
+			e, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("parsing %q: error %v is a %T, want *Error", src, err, err)
+			}
+			if e.Pos < 0 || int(e.Pos) > len(src) {
+				t.Fatalf("parsing %q: error position %d out of bounds", src, e.Pos)
+			}
+			return
+		}
+		roundTrip(t, "fuzz", src)
+	})
+}
@@ -0,0 +1,237 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+// JSON encoding of parse trees.
+
+package parse
+
+import "encoding/json"
+
+// Every node encodes as a JSON object with its "type" and its byte
+// position in the input as "pos", plus the fields specific to the node.
+// Children are nested in the same form, so json.Marshal(tree.Root)
+// produces the whole tree. The names below are part of the format and
+// must not change.
+var nodeNames = map[NodeType]string{
+	NodeAction:       "Action",
+	NodeAlternative:  "Alternative",
+	NodeArrayPattern: "ArrayPattern",
+	NodeAssign:       "Assign",
+	NodeBinary:       "Binary",
+	NodeBind:         "Bind",
+	NodeBool:         "Bool",
+	NodeBranch:       "Branch",
+	NodeCall:         "Call",
+	NodeCase:         "Case",
+	NodeCommand:      "Command",
+	NodeComment:      "Comment",
+	NodeDef:          "Def",
+	NodeFor:          "For",
+	NodeGroup:        "Group",
+	NodeHash:         "Hash",
+	NodeHashPattern:  "HashPattern",
+	NodeIdentifier:   "Identifier",
+	NodeIn:           "In",
+	NodeKeySplat:     "KeySplat",
+	NodeKeyword:      "Keyword",
+	NodeList:         "List",
+	NodeNil:          "Nil",
+	NodeNumber:       "Number",
+	NodeParam:        "Param",
+	NodeRange:        "Range",
+	NodeRegexp:       "Regexp",
+	NodeScope:        "Scope",
+	NodeSplat:        "Splat",
+	NodeString:       "String",
+	NodeSymbol:       "Symbol",
+	NodeWhen:         "When",
+}
+
+// marshalNode encodes n with the given fields.
+func marshalNode(n Node, fields map[string]interface{}) ([]byte, error) {
+	if fields == nil {
+		fields = make(map[string]interface{})
+	}
+	fields["type"] = nodeNames[n.Type()]
+	fields["pos"] = n.Position()
+	return json.Marshal(fields)
+}
+
+func (l *ListNode) MarshalJSON() ([]byte, error) {
+	nodes := l.Nodes
+	if nodes == nil {
+		nodes = []Node{}
+	}
+	return marshalNode(l, map[string]interface{}{"nodes": nodes})
+}
+
+func (a *ActionNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(a, map[string]interface{}{"line": a.Line, "cmd": a.Cmd})
+}
+
+func (c *CommandNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(c, map[string]interface{}{"args": c.Args})
+}
+
+func (c *CommentNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(c, map[string]interface{}{"line": c.Line, "text": c.Text})
+}
+
+func (i *IdentifierNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(i, map[string]interface{}{"ident": i.Ident})
+}
+
+func (n *NilNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(n, nil)
+}
+
+func (b *BoolNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(b, map[string]interface{}{"value": b.True})
+}
+
+func (n *NumberNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(n, map[string]interface{}{"text": n.Text})
+}
+
+func (s *StringNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(s, map[string]interface{}{"quoted": s.Quoted, "text": s.Text})
+}
+
+func (r *RegexpNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(r, map[string]interface{}{"pattern": r.Pattern, "flags": r.Flags})
+}
+
+func (s *SymbolNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(s, map[string]interface{}{"name": s.Name})
+}
+
+func (h *HashNode) MarshalJSON() ([]byte, error) {
+	keys, values := h.Keys, h.Values
+	if keys == nil {
+		keys, values = []string{}, []Node{}
+	}
+	return marshalNode(h, map[string]interface{}{"keys": keys, "values": values})
+}
+
+func (r *RangeNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(r, map[string]interface{}{"low": r.Low, "high": r.High, "exclusive": r.Exclusive})
+}
+
+func (b *BinaryNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(b, map[string]interface{}{"operator": b.Operator, "left": b.Left, "right": b.Right})
+}
+
+func (s *ScopeNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(s, map[string]interface{}{"scope": s.Scope, "name": s.Name})
+}
+
+func (c *CallNode) MarshalJSON() ([]byte, error) {
+	args := c.Args
+	if args == nil {
+		args = []Node{}
+	}
+	return marshalNode(c, map[string]interface{}{"func": c.Func, "args": args})
+}
+
+func (k *KeywordNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(k, map[string]interface{}{"name": k.Name, "value": k.Value})
+}
+
+func (s *KeySplatNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(s, map[string]interface{}{"node": s.Node})
+}
+
+func (f *ForNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(f, map[string]interface{}{
+		"line":       f.Line,
+		"endLine":    f.EndLine,
+		"var":        f.Var,
+		"collection": f.Collection,
+		"list":       f.List,
+	})
+}
+
+func (b *BranchNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(b, map[string]interface{}{"line": b.Line, "keyword": b.Keyword, "value": b.Value})
+}
+
+func (d *DefNode) MarshalJSON() ([]byte, error) {
+	params := d.Params
+	if params == nil {
+		params = []*ParamNode{}
+	}
+	return marshalNode(d, map[string]interface{}{
+		"line":    d.Line,
+		"endLine": d.EndLine,
+		"name":    d.Name,
+		"params":  params,
+		"list":    d.List,
+	})
+}
+
+func (p *ParamNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(p, map[string]interface{}{"kind": p.Kind.String(), "name": p.Name, "default": p.Default})
+}
+
+func (a *AssignNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(a, map[string]interface{}{"line": a.Line, "targets": a.Targets, "values": a.Values})
+}
+
+func (g *GroupNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(g, map[string]interface{}{"targets": g.Targets})
+}
+
+func (s *SplatNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(s, map[string]interface{}{"node": s.Node})
+}
+
+func (c *CaseNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(c, map[string]interface{}{
+		"line":     c.Line,
+		"endLine":  c.EndLine,
+		"subject":  c.Subject,
+		"comments": c.Comments,
+		"clauses":  c.Clauses,
+		"elseLine": c.ElseLine,
+		"else":     c.Else,
+	})
+}
+
+func (w *WhenNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(w, map[string]interface{}{"line": w.Line, "values": w.Values, "list": w.List})
+}
+
+func (i *InNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(i, map[string]interface{}{
+		"line":    i.Line,
+		"pattern": i.Pattern,
+		"guard":   i.Guard,
+		"unless":  i.Unless,
+		"list":    i.List,
+	})
+}
+
+func (a *AlternativeNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(a, map[string]interface{}{"patterns": a.Patterns})
+}
+
+func (a *ArrayPatternNode) MarshalJSON() ([]byte, error) {
+	elems := a.Elems
+	if elems == nil {
+		elems = []Node{}
+	}
+	return marshalNode(a, map[string]interface{}{"elems": elems})
+}
+
+func (h *HashPatternNode) MarshalJSON() ([]byte, error) {
+	keys, patterns := h.Keys, h.Patterns
+	if keys == nil {
+		keys, patterns = []string{}, []Node{}
+	}
+	return marshalNode(h, map[string]interface{}{"keys": keys, "patterns": patterns})
+}
+
+func (b *BindNode) MarshalJSON() ([]byte, error) {
+	return marshalNode(b, map[string]interface{}{"pattern": b.Pattern, "name": b.Name})
+}
@@ -9,6 +9,11 @@ package parse
 import (
 	"bytes"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/carlosbrando/furby/scanner"
 )
 
 // A Node is an element in the parse tree. The interface is trivial.
@@ -37,6 +42,12 @@ func (p Pos) Position() Pos {
 	return p
 }
 
+// shift moves the position by d. Reparse uses it on the nodes it reuses
+// from after an edit.
+func (p *Pos) shift(d Pos) {
+	*p += d
+}
+
 // unexported keeps Node implementations local to the package.
 // All implementations embed Pos, so this takes care of it.
 func (Pos) unexported() {
@@ -49,25 +60,49 @@ func (t NodeType) Type() NodeType {
 }
 
 const (
-	NodeText   NodeType = iota // Plain text.
-	NodeAction                 // A non-control action such as a field evaluation.
-	// NodeBool                       // A boolean constant.
+	NodeText         NodeType = iota // Plain text.
+	NodeAction                       // A non-control action such as a field evaluation.
+	NodeAlternative                  // Patterns that match if any of them does.
+	NodeArrayPattern                 // A pattern over the elements of an array.
+	NodeAssign                       // An assignment of values to targets.
+	NodeBinary                       // A binary operation, such as a == b.
+	NodeBind                         // A pattern that binds what it matched to a name.
+	NodeBool                         // A boolean constant.
+	NodeBranch                       // A break, next or redo statement.
+	NodeCall                         // A method call with parenthesized arguments.
+	NodeCase                         // A case statement.
 	// NodeChain                      // A sequence of field accesses.
-	// NodeCommand                    // An element of a pipeline.
+	nodeClause  // A when or in keyword. Not added to tree.
+	NodeCommand // An element of a pipeline.
+	NodeComment // A comment, from '#' to the end of the line.
+	NodeDef     // A method definition.
 	// NodeDot                        // The cursor, dot.
-	// nodeElse                       // An else action. Not added to tree.
-	nodeEnd // An end action. Not added to tree.
+	nodeElse // An else keyword. Not added to tree.
+	nodeEnd  // An end action. Not added to tree.
 	// NodeField                      // A field or method name.
-	// NodeIdentifier                 // An identifier; always a function name.
+	NodeFor         // A for loop.
+	NodeGroup       // Parenthesized assignment targets.
+	NodeHash        // A hash literal with symbol keys.
+	NodeHashPattern // A pattern over the keys of a hash.
+	NodeIdentifier  // An identifier; always a function name.
+	NodeIn          // An in clause of a case statement.
+	NodeKeySplat    // A hash expanded into keyword arguments, as **opts.
+	NodeKeyword     // A keyword argument of a call, as key: 2.
 	// NodeIf                         // An if action.
-	NodeList // A list of Nodes.
-	// NodeNil                        // An untyped nil constant.
-	// NodeNumber                     // A numerical constant.
+	NodeList   // A list of Nodes.
+	NodeNil    // An untyped nil constant.
+	NodeNumber // A numerical constant.
+	NodeParam  // A parameter of a method definition.
 	// NodePipe                       // A pipeline of commands.
-	// NodeRange                      // A range action.
-	// NodeString                     // A string constant.
+	NodeRange  // A range of values, low..high or low...high.
+	NodeRegexp // A regular expression literal.
+	NodeScope  // A constant looked up in a module, as Net::Http.
+	NodeSplat  // A value or target prefixed with *.
+	NodeString // A string constant.
+	NodeSymbol // A symbol constant.
 	// NodeTemplate                   // A template invocation action.
 	// NodeVariable                   // A $ variable.
+	NodeWhen // A when clause of a case statement.
 	// NodeWith                       // A with action.
 )
 
@@ -88,14 +123,75 @@ func (l *ListNode) append(n Node) {
 	l.Nodes = append(l.Nodes, n)
 }
 
+// String returns the list as source text, one node per line. A comment
+// that shared its line with the statement before it stays on that line,
+// and a run of blank lines between nodes is kept as a single one.
 func (l *ListNode) String() string {
 	b := new(bytes.Buffer)
-	for _, n := range l.Nodes {
-		fmt.Fprint(b, n)
-	}
+	l.writeTo(b, 0, "", false)
 	return b.String()
 }
 
+// writeTo prints the list to b with every line indented by indent. line
+// is the last line of what was printed before the list, 0 if nothing.
+// If then is set, the list is the body of a clause and a first statement
+// on that line follows the then keyword.
+func (l *ListNode) writeTo(b *bytes.Buffer, line int, indent string, then bool) {
+	afterComment := false
+	for i, n := range l.Nodes {
+		first, last := lines(n)
+		if first == 0 {
+			first, last = line+1, line+1
+		}
+		switch {
+		case line == 0:
+		case first == line && n.Type() == NodeComment && !afterComment:
+			b.WriteByte(' ')
+		case i == 0 && then && first == line && n.Type() != NodeComment:
+			b.WriteString(" then ")
+		case first > line+1:
+			b.WriteString("\n\n" + indent)
+		default:
+			b.WriteString("\n" + indent)
+		}
+		if block, ok := n.(block); ok {
+			block.writeTo(b, indent)
+		} else {
+			fmt.Fprint(b, n)
+		}
+		line, afterComment = last, n.Type() == NodeComment
+	}
+}
+
+// lines returns the first and last lines of a statement, or 0, 0 if n
+// does not record them.
+func lines(n Node) (first, last int) {
+	switch n := n.(type) {
+	case *ActionNode:
+		return n.Line, n.Line
+	case *AssignNode:
+		return n.Line, n.Line
+	case *BranchNode:
+		return n.Line, n.Line
+	case *CaseNode:
+		return n.Line, n.EndLine
+	case *CommentNode:
+		return n.Line, n.Line
+	case *DefNode:
+		return n.Line, n.EndLine
+	case *ForNode:
+		return n.Line, n.EndLine
+	}
+	return 0, 0
+}
+
+// block is implemented by the statements that run until an end keyword
+// and print their body indented.
+type block interface {
+	Node
+	writeTo(b *bytes.Buffer, indent string)
+}
+
 func (l *ListNode) CopyList() *ListNode {
 	if l == nil {
 		return l
@@ -117,22 +213,99 @@ func (l *ListNode) Copy() Node {
 type ActionNode struct {
 	NodeType
 	Pos
-	Line int // The line number in the input (deprecated; kept for compatibility)
+	Line int          // The line number in the input (deprecated; kept for compatibility)
+	Cmd  *CommandNode // The command to evaluate.
 }
 
 func (a *ActionNode) String() string {
-	// return fmt.Sprintf("{{%s}}", a.Pipe)
-	return ""
-
+	return a.Cmd.String()
 }
 
 func (a *ActionNode) Copy() Node {
-	return newAction(a.Pos, a.Line)
+	return newAction(a.Pos, a.Line, a.Cmd.CopyCommand())
 
 }
 
-func newAction(pos Pos, line int) *ActionNode {
-	return &ActionNode{NodeType: NodeAction, Pos: pos, Line: line}
+func newAction(pos Pos, line int, cmd *CommandNode) *ActionNode {
+	return &ActionNode{NodeType: NodeAction, Pos: pos, Line: line, Cmd: cmd}
+}
+
+// AssignNode holds an assignment, such as a = 1 or a, *b = list.
+type AssignNode struct {
+	NodeType
+	Pos
+	Line    int    // The line number in the input.
+	Targets []Node // Identifiers, splats and groups, in lexical order.
+	Values  []Node // Operands and splats, in lexical order.
+}
+
+func newAssign(pos Pos, line int, targets, values []Node) *AssignNode {
+	return &AssignNode{NodeType: NodeAssign, Pos: pos, Line: line, Targets: targets, Values: values}
+}
+
+func (a *AssignNode) String() string {
+	return joinNodes(a.Targets) + " = " + joinNodes(a.Values)
+}
+
+func (a *AssignNode) Copy() Node {
+	return newAssign(a.Pos, a.Line, copyNodes(a.Targets), copyNodes(a.Values))
+}
+
+// GroupNode holds parenthesized assignment targets, which take the
+// elements of a single value, as (k, v) in (k, v), i = pair, 0.
+type GroupNode struct {
+	NodeType
+	Pos
+	Targets []Node // Identifiers, splats and groups, in lexical order.
+}
+
+func newGroup(pos Pos, targets []Node) *GroupNode {
+	return &GroupNode{NodeType: NodeGroup, Pos: pos, Targets: targets}
+}
+
+func (g *GroupNode) String() string {
+	return "(" + joinNodes(g.Targets) + ")"
+}
+
+func (g *GroupNode) Copy() Node {
+	return newGroup(g.Pos, copyNodes(g.Targets))
+}
+
+// SplatNode holds a value expanded into its elements or a target that
+// collects the remaining ones, as *rest.
+type SplatNode struct {
+	NodeType
+	Pos
+	Node Node // The value or target.
+}
+
+func newSplat(pos Pos, node Node) *SplatNode {
+	return &SplatNode{NodeType: NodeSplat, Pos: pos, Node: node}
+}
+
+func (s *SplatNode) String() string {
+	return "*" + s.Node.String()
+}
+
+func (s *SplatNode) Copy() Node {
+	return newSplat(s.Pos, s.Node.Copy())
+}
+
+// joinNodes prints nodes separated by commas.
+func joinNodes(nodes []Node) string {
+	s := make([]string, len(nodes))
+	for i, n := range nodes {
+		s[i] = n.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+func copyNodes(nodes []Node) []Node {
+	c := make([]Node, len(nodes))
+	for i, n := range nodes {
+		c[i] = n.Copy()
+	}
+	return c
 }
 
 // CommandNode holds a command (a pipeline inside an evaluating action).
@@ -142,6 +315,80 @@ type CommandNode struct {
 	Args []Node // Arguments in lexical order: Identifier, field, or constant.
 }
 
+func newCommand(pos Pos) *CommandNode {
+	return &CommandNode{NodeType: NodeCommand, Pos: pos}
+}
+
+func (c *CommandNode) append(arg Node) {
+	c.Args = append(c.Args, arg)
+}
+
+func (c *CommandNode) String() string {
+	s := ""
+	for i, arg := range c.Args {
+		if i > 0 {
+			s += " "
+		}
+		s += arg.String()
+	}
+	return s
+}
+
+func (c *CommandNode) CopyCommand() *CommandNode {
+	if c == nil {
+		return c
+	}
+	n := newCommand(c.Pos)
+	for _, c := range c.Args {
+		n.append(c.Copy())
+	}
+	return n
+}
+
+func (c *CommandNode) Copy() Node {
+	return c.CopyCommand()
+}
+
+// CommentNode holds a comment. Comments are kept in the tree so that
+// tools printing it back can preserve them.
+type CommentNode struct {
+	NodeType
+	Pos
+	Line int    // The line number in the input.
+	Text string // The comment text, including the leading '#'.
+}
+
+func newComment(pos Pos, line int, text string) *CommentNode {
+	return &CommentNode{NodeType: NodeComment, Pos: pos, Line: line, Text: text}
+}
+
+func (c *CommentNode) String() string {
+	return c.Text
+}
+
+func (c *CommentNode) Copy() Node {
+	return newComment(c.Pos, c.Line, c.Text)
+}
+
+// IdentifierNode holds an identifier.
+type IdentifierNode struct {
+	NodeType
+	Pos
+	Ident string // The identifier's name.
+}
+
+func newIdentifier(pos Pos, ident string) *IdentifierNode {
+	return &IdentifierNode{NodeType: NodeIdentifier, Pos: pos, Ident: ident}
+}
+
+func (i *IdentifierNode) String() string {
+	return i.Ident
+}
+
+func (i *IdentifierNode) Copy() Node {
+	return newIdentifier(i.Pos, i.Ident)
+}
+
 // VariableNode holds a list of variable names, possibly with chained field
 // accesses. The dollar sign is part of the (first) name.
 type VariableNode struct {
@@ -149,3 +396,893 @@ type VariableNode struct {
 	Pos
 	Ident []string // Variable name and fields in lexical order.
 }
+
+// NilNode holds the special identifier 'nil' representing an untyped nil constant.
+type NilNode struct {
+	NodeType
+	Pos
+}
+
+func newNil(pos Pos) *NilNode {
+	return &NilNode{NodeType: NodeNil, Pos: pos}
+}
+
+func (n *NilNode) String() string {
+	return "nil"
+}
+
+func (n *NilNode) Copy() Node {
+	return newNil(n.Pos)
+}
+
+// BoolNode holds a boolean constant.
+type BoolNode struct {
+	NodeType
+	Pos
+	True bool // The value of the boolean constant.
+}
+
+func newBool(pos Pos, true bool) *BoolNode {
+	return &BoolNode{NodeType: NodeBool, Pos: pos, True: true}
+}
+
+func (b *BoolNode) String() string {
+	if b.True {
+		return "true"
+	}
+	return "false"
+}
+
+func (b *BoolNode) Copy() Node {
+	return newBool(b.Pos, b.True)
+}
+
+// NumberNode holds a number: signed integer, float, or complex.
+// The value is parsed and stored under all the types that can represent the value.
+// This simulates in a small amount of code the behavior of Go's ideal constants.
+type NumberNode struct {
+	NodeType
+	Pos
+	IsInt      bool       // Number has an integral value.
+	IsFloat    bool       // Number has a floating-point value.
+	IsComplex  bool       // Number is complex.
+	Int64      int64      // The signed integer value.
+	Float64    float64    // The floating-point value.
+	Complex128 complex128 // The complex value.
+	Text       string     // The original textual representation from the input.
+}
+
+func newNumber(pos Pos, text string, kind scanner.Kind) (*NumberNode, error) {
+	n := &NumberNode{NodeType: NodeNumber, Pos: pos, Text: text}
+	if kind == scanner.Complex {
+		// fmt.Sscan can parse the pair, so let it do the work.
+		if _, err := fmt.Sscan(text, &n.Complex128); err != nil {
+			return nil, err
+		}
+		n.IsComplex = true
+		return n, nil
+	}
+	// Imaginary constants can only be complex unless they are zero.
+	if len(text) > 0 && text[len(text)-1] == 'i' {
+		f, err := strconv.ParseFloat(text[:len(text)-1], 64)
+		if err == nil {
+			n.IsComplex = true
+			n.Complex128 = complex(0, f)
+			return n, nil
+		}
+	}
+	// Do integer test first so we get 0x123 etc.
+	i, err := strconv.ParseInt(text, 0, 64)
+	if err == nil {
+		n.IsInt = true
+		n.Int64 = i
+	}
+	// If an integer extraction succeeded, promote the float.
+	if n.IsInt {
+		n.IsFloat = true
+		n.Float64 = float64(n.Int64)
+	} else {
+		f, err := strconv.ParseFloat(text, 64)
+		if err == nil {
+			// If we parsed it as a float but it looks like an integer,
+			// it's a huge number too large to fit in an int. Reject it.
+			if !strings.ContainsAny(text, ".eE") {
+				return nil, fmt.Errorf("integer overflow: %q", text)
+			}
+			n.IsFloat = true
+			n.Float64 = f
+			// If a floating-point extraction succeeded, extract the int if needed.
+			if float64(int64(f)) == f {
+				n.IsInt = true
+				n.Int64 = int64(f)
+			}
+		}
+	}
+	if !n.IsInt && !n.IsFloat {
+		return nil, fmt.Errorf("illegal number syntax: %q", text)
+	}
+	return n, nil
+}
+
+func (n *NumberNode) String() string {
+	return n.Text
+}
+
+func (n *NumberNode) Copy() Node {
+	nn := new(NumberNode)
+	*nn = *n // Easy, fast, correct.
+	return nn
+}
+
+// StringNode holds a string constant. The value has been "unquoted".
+type StringNode struct {
+	NodeType
+	Pos
+	Quoted string // The original text of the string, with quotes.
+	Text   string // The string, after quote processing.
+}
+
+func newString(pos Pos, orig, text string) *StringNode {
+	return &StringNode{NodeType: NodeString, Pos: pos, Quoted: orig, Text: text}
+}
+
+// String returns the string as written if it is in double quotes. A
+// string in single quotes is printed in double quotes, the canonical
+// form.
+func (s *StringNode) String() string {
+	if s.Quoted[0] == '\'' {
+		return quote(s.Text)
+	}
+	return s.Quoted
+}
+
+func (s *StringNode) Copy() Node {
+	return newString(s.Pos, s.Quoted, s.Text)
+}
+
+// RangeNode holds a range of values.
+type RangeNode struct {
+	NodeType
+	Pos
+	Low       Node // The first value.
+	High      Node // The last value.
+	Exclusive bool // Whether High is left out of the range, as in low...high.
+}
+
+func newRange(pos Pos, low, high Node, exclusive bool) *RangeNode {
+	return &RangeNode{NodeType: NodeRange, Pos: pos, Low: low, High: high, Exclusive: exclusive}
+}
+
+func (r *RangeNode) String() string {
+	if r.Exclusive {
+		return r.Low.String() + "..." + r.High.String()
+	}
+	return r.Low.String() + ".." + r.High.String()
+}
+
+func (r *RangeNode) Copy() Node {
+	return newRange(r.Pos, r.Low.Copy(), r.High.Copy(), r.Exclusive)
+}
+
+// BinaryNode holds an operation on two values, such as a == b.
+type BinaryNode struct {
+	NodeType
+	Pos
+	Operator string // The operator, such as == or &&.
+	Left     Node   // The value before the operator.
+	Right    Node   // The value after the operator.
+}
+
+func newBinary(pos Pos, operator string, left, right Node) *BinaryNode {
+	return &BinaryNode{NodeType: NodeBinary, Pos: pos, Operator: operator, Left: left, Right: right}
+}
+
+// String prints the operation with a space on each side of the operator.
+// The parser only produces operands that bind tighter than the operator,
+// so no parentheses are needed.
+func (b *BinaryNode) String() string {
+	return b.Left.String() + " " + b.Operator + " " + b.Right.String()
+}
+
+func (b *BinaryNode) Copy() Node {
+	return newBinary(b.Pos, b.Operator, b.Left.Copy(), b.Right.Copy())
+}
+
+// ScopeNode holds a constant looked up in a module or class, as Http in
+// Net::Http.
+type ScopeNode struct {
+	NodeType
+	Pos
+	Scope Node   // Where the constant is looked up: an identifier or another ScopeNode.
+	Name  string // The name of the constant.
+}
+
+func newScope(pos Pos, scope Node, name string) *ScopeNode {
+	return &ScopeNode{NodeType: NodeScope, Pos: pos, Scope: scope, Name: name}
+}
+
+func (s *ScopeNode) String() string {
+	return s.Scope.String() + "::" + s.Name
+}
+
+func (s *ScopeNode) Copy() Node {
+	return newScope(s.Pos, s.Scope.Copy(), s.Name)
+}
+
+// CallNode holds a method call with its arguments in parentheses, as
+// f(1, *rest, key: 2, **opts).
+type CallNode struct {
+	NodeType
+	Pos
+	Func Node   // The method: an identifier, a constant or a ScopeNode.
+	Args []Node // Values, splats, keyword arguments and keyword splats, in lexical order.
+}
+
+func newCall(pos Pos, fn Node, args []Node) *CallNode {
+	return &CallNode{NodeType: NodeCall, Pos: pos, Func: fn, Args: args}
+}
+
+func (c *CallNode) String() string {
+	return c.Func.String() + "(" + joinNodes(c.Args) + ")"
+}
+
+func (c *CallNode) Copy() Node {
+	return newCall(c.Pos, c.Func.Copy(), copyNodes(c.Args))
+}
+
+// KeywordNode holds a keyword argument of a call, as key: 2.
+type KeywordNode struct {
+	NodeType
+	Pos
+	Name  string // The keyword, without its colon.
+	Value Node   // The argument.
+}
+
+func newKeyword(pos Pos, name string, value Node) *KeywordNode {
+	return &KeywordNode{NodeType: NodeKeyword, Pos: pos, Name: name, Value: value}
+}
+
+func (k *KeywordNode) String() string {
+	return k.Name + ": " + k.Value.String()
+}
+
+func (k *KeywordNode) Copy() Node {
+	return newKeyword(k.Pos, k.Name, k.Value.Copy())
+}
+
+// KeySplatNode holds a hash expanded into the keyword arguments of a
+// call, as **opts.
+type KeySplatNode struct {
+	NodeType
+	Pos
+	Node Node // The hash.
+}
+
+func newKeySplat(pos Pos, node Node) *KeySplatNode {
+	return &KeySplatNode{NodeType: NodeKeySplat, Pos: pos, Node: node}
+}
+
+func (s *KeySplatNode) String() string {
+	return "**" + s.Node.String()
+}
+
+func (s *KeySplatNode) Copy() Node {
+	return newKeySplat(s.Pos, s.Node.Copy())
+}
+
+// ForNode holds a for loop, which runs List once for each element of
+// Collection with the element in the variable Var.
+type ForNode struct {
+	NodeType
+	Pos
+	Line       int       // The line number of the for keyword.
+	EndLine    int       // The line number of the end keyword.
+	Var        string    // The name of the loop variable.
+	Collection Node      // The value iterated over.
+	List       *ListNode // What to execute for each element.
+}
+
+func newFor(pos Pos, line, endLine int, name string, collection Node, list *ListNode) *ForNode {
+	return &ForNode{NodeType: NodeFor, Pos: pos, Line: line, EndLine: endLine, Var: name, Collection: collection, List: list}
+}
+
+func (f *ForNode) String() string {
+	b := new(bytes.Buffer)
+	f.writeTo(b, "")
+	return b.String()
+}
+
+// writeTo prints the loop to b, indenting its body one tab more than
+// indent.
+func (f *ForNode) writeTo(b *bytes.Buffer, indent string) {
+	fmt.Fprintf(b, "for %s in %s", f.Var, f.Collection)
+	f.List.writeTo(b, f.Line, indent+"\t", false)
+	b.WriteString("\n" + indent + "end")
+}
+
+func (f *ForNode) Copy() Node {
+	return newFor(f.Pos, f.Line, f.EndLine, f.Var, f.Collection.Copy(), f.List.CopyList())
+}
+
+// DefNode holds a method definition.
+type DefNode struct {
+	NodeType
+	Pos
+	Line    int          // The line number of the def keyword.
+	EndLine int          // The line number of the end keyword.
+	Name    string       // The name of the method.
+	Params  []*ParamNode // The parameters in lexical order.
+	List    *ListNode    // The body of the method.
+}
+
+func newDef(pos Pos, line, endLine int, name string, params []*ParamNode, list *ListNode) *DefNode {
+	return &DefNode{NodeType: NodeDef, Pos: pos, Line: line, EndLine: endLine, Name: name, Params: params, List: list}
+}
+
+func (d *DefNode) String() string {
+	b := new(bytes.Buffer)
+	d.writeTo(b, "")
+	return b.String()
+}
+
+// writeTo prints the definition to b, indenting its body one tab more
+// than indent.
+func (d *DefNode) writeTo(b *bytes.Buffer, indent string) {
+	b.WriteString("def " + d.Name)
+	for i, p := range d.Params {
+		if i == 0 {
+			b.WriteByte('(')
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(p.String())
+	}
+	if len(d.Params) > 0 {
+		b.WriteByte(')')
+	}
+	d.List.writeTo(b, d.Line, indent+"\t", false)
+	b.WriteString("\n" + indent + "end")
+}
+
+func (d *DefNode) Copy() Node {
+	params := make([]*ParamNode, len(d.Params))
+	for i, p := range d.Params {
+		params[i] = p.CopyParam()
+	}
+	return newDef(d.Pos, d.Line, d.EndLine, d.Name, params, d.List.CopyList())
+}
+
+// ParamKind tells how a parameter receives its argument.
+type ParamKind int
+
+const (
+	ParamRequired    ParamKind = iota // a
+	ParamOptional                     // a = 1
+	ParamRest                         // *a
+	ParamKey                          // a:
+	ParamKeyOptional                  // a: 1
+	ParamKeyRest                      // **a
+	ParamBlock                        // &a
+)
+
+var paramKindNames = []string{"required", "optional", "rest", "key", "keyOptional", "keyRest", "block"}
+
+func (k ParamKind) String() string {
+	return paramKindNames[k]
+}
+
+// ParamNode holds a parameter of a method definition.
+type ParamNode struct {
+	NodeType
+	Pos
+	Kind    ParamKind // How the parameter receives its argument.
+	Name    string    // The name of the parameter.
+	Default Node      // The default value of an optional parameter; nil otherwise.
+}
+
+func newParam(pos Pos, kind ParamKind, name string, value Node) *ParamNode {
+	return &ParamNode{NodeType: NodeParam, Pos: pos, Kind: kind, Name: name, Default: value}
+}
+
+func (p *ParamNode) String() string {
+	switch p.Kind {
+	case ParamOptional:
+		return p.Name + " = " + p.Default.String()
+	case ParamRest:
+		return "*" + p.Name
+	case ParamKey:
+		return p.Name + ":"
+	case ParamKeyOptional:
+		return p.Name + ": " + p.Default.String()
+	case ParamKeyRest:
+		return "**" + p.Name
+	case ParamBlock:
+		return "&" + p.Name
+	}
+	return p.Name
+}
+
+func (p *ParamNode) CopyParam() *ParamNode {
+	if p.Default == nil {
+		return newParam(p.Pos, p.Kind, p.Name, nil)
+	}
+	return newParam(p.Pos, p.Kind, p.Name, p.Default.Copy())
+}
+
+func (p *ParamNode) Copy() Node {
+	return p.CopyParam()
+}
+
+// BranchNode holds a break, next or redo statement inside a for loop.
+type BranchNode struct {
+	NodeType
+	Pos
+	Line    int    // The line number in the input.
+	Keyword string // break, next or redo.
+	Value   Node   // The value given to break or next, if any.
+}
+
+func newBranch(pos Pos, line int, keyword string, value Node) *BranchNode {
+	return &BranchNode{NodeType: NodeBranch, Pos: pos, Line: line, Keyword: keyword, Value: value}
+}
+
+func (b *BranchNode) String() string {
+	if b.Value == nil {
+		return b.Keyword
+	}
+	return b.Keyword + " " + b.Value.String()
+}
+
+func (b *BranchNode) Copy() Node {
+	if b.Value == nil {
+		return newBranch(b.Pos, b.Line, b.Keyword, nil)
+	}
+	return newBranch(b.Pos, b.Line, b.Keyword, b.Value.Copy())
+}
+
+// SymbolNode holds a symbol constant, :name.
+type SymbolNode struct {
+	NodeType
+	Pos
+	Name string // The name of the symbol, without the colon.
+}
+
+func newSymbol(pos Pos, name string) *SymbolNode {
+	return &SymbolNode{NodeType: NodeSymbol, Pos: pos, Name: name}
+}
+
+func (s *SymbolNode) String() string {
+	return ":" + s.Name
+}
+
+func (s *SymbolNode) Copy() Node {
+	return newSymbol(s.Pos, s.Name)
+}
+
+// HashNode holds a hash literal whose keys are symbols, written as
+// labels: {name: "x", age: 2}.
+type HashNode struct {
+	NodeType
+	Pos
+	Keys   []string // The keys, without their colons, in lexical order.
+	Values []Node   // The value for each key.
+}
+
+func newHash(pos Pos) *HashNode {
+	return &HashNode{NodeType: NodeHash, Pos: pos}
+}
+
+func (h *HashNode) append(key string, value Node) {
+	h.Keys = append(h.Keys, key)
+	h.Values = append(h.Values, value)
+}
+
+func (h *HashNode) String() string {
+	s := make([]string, len(h.Keys))
+	for i, key := range h.Keys {
+		s[i] = key + ": " + h.Values[i].String()
+	}
+	return "{" + strings.Join(s, ", ") + "}"
+}
+
+func (h *HashNode) Copy() Node {
+	n := newHash(h.Pos)
+	for i, key := range h.Keys {
+		n.append(key, h.Values[i].Copy())
+	}
+	return n
+}
+
+// RegexpNode holds a regular expression literal, compiled with Go's
+// regexp package.
+type RegexpNode struct {
+	NodeType
+	Pos
+	Quoted  string         // The original text of the literal, with slashes and flags.
+	Pattern string         // The pattern between the slashes.
+	Flags   string         // The flags after the closing slash.
+	Regexp  *regexp.Regexp // The compiled expression.
+}
+
+// regexpFlags maps each flag of a literal to the Go flag it stands for.
+// 'x' has no Go counterpart and is handled by stripping the pattern.
+var regexpFlags = map[rune]string{
+	'i': "i", // case-insensitive
+	'm': "s", // let . match \n
+	'x': "",  // extended: ignore whitespace and # comments
+}
+
+func newRegexp(pos Pos, orig string) (*RegexpNode, error) {
+	end := strings.LastIndex(orig, "/")
+	r := &RegexpNode{NodeType: NodeRegexp, Pos: pos, Quoted: orig, Pattern: orig[1:end], Flags: orig[end+1:]}
+
+	expr, goFlags := r.Pattern, ""
+	for _, f := range r.Flags {
+		goFlag, ok := regexpFlags[f]
+		if !ok {
+			return nil, fmt.Errorf("unknown regular expression flag %q in %s", f, orig)
+		}
+		if f == 'x' {
+			expr = stripExtended(expr)
+		}
+		if !strings.Contains(goFlags, goFlag) {
+			goFlags += goFlag
+		}
+	}
+	if goFlags != "" {
+		expr = "(?" + goFlags + ")" + expr
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("bad regular expression %s: %s", orig, err)
+	}
+	r.Regexp = re
+	return r, nil
+}
+
+// stripExtended removes the unescaped whitespace and the # comment
+// from a pattern written with the x flag. Both are literal inside a
+// character class, which is copied as it is.
+func stripExtended(pattern string) string {
+	var b bytes.Buffer
+	depth := 0 // nesting of character classes, as in [a[:digit:]]
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\' && i+1 < len(pattern):
+			b.WriteString(pattern[i : i+2])
+			i++
+		case c == '[':
+			// A ] first in the class, or right after ^, is literal.
+			j := i + 1
+			if j < len(pattern) && pattern[j] == '^' {
+				j++
+			}
+			if j < len(pattern) && pattern[j] == ']' {
+				j++
+			}
+			b.WriteString(pattern[i:j])
+			i = j - 1
+			depth++
+		case c == ']' && depth > 0:
+			b.WriteByte(c)
+			depth--
+		case depth > 0:
+			b.WriteByte(c)
+		case c == '#':
+			return b.String()
+		case c == ' ' || c == '\t':
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func (r *RegexpNode) String() string {
+	return r.Quoted
+}
+
+func (r *RegexpNode) Copy() Node {
+	n := new(RegexpNode)
+	*n = *r // The compiled expression is safe to share.
+	return n
+}
+
+// CaseNode holds a case statement. Its clauses are all when clauses,
+// which compare the subject with values, or all in clauses, which
+// match it against patterns.
+type CaseNode struct {
+	NodeType
+	Pos
+	Line     int       // The line number of the case keyword.
+	EndLine  int       // The line number of the end keyword.
+	Subject  Node      // The value examined; nil if there is none.
+	Comments *ListNode // Comments between the case line and the first clause.
+	Clauses  []Node    // The WhenNodes or InNodes, in lexical order.
+	ElseLine int       // The line number of the else keyword, if any.
+	Else     *ListNode // What to execute if no clause applies; nil if there is no else.
+}
+
+func newCase(pos Pos, line int, subject Node) *CaseNode {
+	return &CaseNode{NodeType: NodeCase, Pos: pos, Line: line, Subject: subject}
+}
+
+func (c *CaseNode) String() string {
+	b := new(bytes.Buffer)
+	c.writeTo(b, "")
+	return b.String()
+}
+
+// writeTo prints the statement to b with the clauses at indent and
+// their bodies one tab further in.
+func (c *CaseNode) writeTo(b *bytes.Buffer, indent string) {
+	b.WriteString("case")
+	if c.Subject != nil {
+		b.WriteString(" " + c.Subject.String())
+	}
+	c.Comments.writeTo(b, c.Line, indent, false)
+	for _, clause := range c.Clauses {
+		switch clause := clause.(type) {
+		case *WhenNode:
+			b.WriteString("\n" + indent + clause.header())
+			clause.List.writeTo(b, clause.Line, indent+"\t", true)
+		case *InNode:
+			b.WriteString("\n" + indent + clause.header())
+			clause.List.writeTo(b, clause.Line, indent+"\t", true)
+		}
+	}
+	if c.Else != nil {
+		b.WriteString("\n" + indent + "else")
+		c.Else.writeTo(b, c.ElseLine, indent+"\t", false)
+	}
+	b.WriteString("\n" + indent + "end")
+}
+
+func (c *CaseNode) Copy() Node {
+	n := newCase(c.Pos, c.Line, nil)
+	if c.Subject != nil {
+		n.Subject = c.Subject.Copy()
+	}
+	n.EndLine = c.EndLine
+	n.Comments = c.Comments.CopyList()
+	n.Clauses = copyNodes(c.Clauses)
+	n.ElseLine = c.ElseLine
+	n.Else = c.Else.CopyList()
+	return n
+}
+
+// WhenNode holds a when clause, which applies if any of its values
+// matches the subject of the case.
+type WhenNode struct {
+	NodeType
+	Pos
+	Line   int       // The line number of the when keyword.
+	Values []Node    // Operands and splats, in lexical order.
+	List   *ListNode // What to execute if the clause applies.
+}
+
+func newWhen(pos Pos, line int, values []Node, list *ListNode) *WhenNode {
+	return &WhenNode{NodeType: NodeWhen, Pos: pos, Line: line, Values: values, List: list}
+}
+
+// header returns the clause up to its body.
+func (w *WhenNode) header() string {
+	return "when " + joinNodes(w.Values)
+}
+
+func (w *WhenNode) String() string {
+	return w.header() + "\n" + w.List.String()
+}
+
+func (w *WhenNode) Copy() Node {
+	return newWhen(w.Pos, w.Line, copyNodes(w.Values), w.List.CopyList())
+}
+
+// InNode holds an in clause, which applies if its pattern matches the
+// subject of the case and its guard, if any, allows it.
+type InNode struct {
+	NodeType
+	Pos
+	Line    int       // The line number of the in keyword.
+	Pattern Node      // The pattern to match.
+	Guard   Node      // The condition after if or unless; nil if there is none.
+	Unless  bool      // Whether the guard is an unless.
+	List    *ListNode // What to execute if the clause applies.
+}
+
+func newIn(pos Pos, line int, pattern, guard Node, unless bool, list *ListNode) *InNode {
+	return &InNode{NodeType: NodeIn, Pos: pos, Line: line, Pattern: pattern, Guard: guard, Unless: unless, List: list}
+}
+
+// header returns the clause up to its body.
+func (i *InNode) header() string {
+	s := "in " + i.Pattern.String()
+	switch {
+	case i.Guard == nil:
+	case i.Unless:
+		s += " unless " + i.Guard.String()
+	default:
+		s += " if " + i.Guard.String()
+	}
+	return s
+}
+
+func (i *InNode) String() string {
+	return i.header() + "\n" + i.List.String()
+}
+
+func (i *InNode) Copy() Node {
+	var guard Node
+	if i.Guard != nil {
+		guard = i.Guard.Copy()
+	}
+	return newIn(i.Pos, i.Line, i.Pattern.Copy(), guard, i.Unless, i.List.CopyList())
+}
+
+// AlternativeNode holds patterns separated by |, any of which may match.
+type AlternativeNode struct {
+	NodeType
+	Pos
+	Patterns []Node // The alternatives, in lexical order.
+}
+
+func newAlternative(pos Pos, patterns []Node) *AlternativeNode {
+	return &AlternativeNode{NodeType: NodeAlternative, Pos: pos, Patterns: patterns}
+}
+
+func (a *AlternativeNode) String() string {
+	s := make([]string, len(a.Patterns))
+	for i, p := range a.Patterns {
+		s[i] = p.String()
+	}
+	return strings.Join(s, " | ")
+}
+
+func (a *AlternativeNode) Copy() Node {
+	return newAlternative(a.Pos, copyNodes(a.Patterns))
+}
+
+// ArrayPatternNode holds a pattern that matches an array element by
+// element, as [first, *rest].
+type ArrayPatternNode struct {
+	NodeType
+	Pos
+	Elems []Node // Patterns and at most one splat, in lexical order.
+}
+
+func newArrayPattern(pos Pos, elems []Node) *ArrayPatternNode {
+	return &ArrayPatternNode{NodeType: NodeArrayPattern, Pos: pos, Elems: elems}
+}
+
+func (a *ArrayPatternNode) String() string {
+	return "[" + joinNodes(a.Elems) + "]"
+}
+
+func (a *ArrayPatternNode) Copy() Node {
+	return newArrayPattern(a.Pos, copyNodes(a.Elems))
+}
+
+// HashPatternNode holds a pattern that matches the values of some keys
+// of a hash, as {name: String, age:}. A key without a pattern binds its
+// value to a variable of the same name.
+type HashPatternNode struct {
+	NodeType
+	Pos
+	Keys     []string // The keys, without their colons, in lexical order.
+	Patterns []Node   // The pattern for each key; nil for a bare key.
+}
+
+func newHashPattern(pos Pos) *HashPatternNode {
+	return &HashPatternNode{NodeType: NodeHashPattern, Pos: pos}
+}
+
+func (h *HashPatternNode) append(key string, pattern Node) {
+	h.Keys = append(h.Keys, key)
+	h.Patterns = append(h.Patterns, pattern)
+}
+
+func (h *HashPatternNode) String() string {
+	s := make([]string, len(h.Keys))
+	for i, key := range h.Keys {
+		s[i] = key + ":"
+		if h.Patterns[i] != nil {
+			s[i] += " " + h.Patterns[i].String()
+		}
+	}
+	return "{" + strings.Join(s, ", ") + "}"
+}
+
+func (h *HashPatternNode) Copy() Node {
+	n := newHashPattern(h.Pos)
+	for i, key := range h.Keys {
+		var pattern Node
+		if h.Patterns[i] != nil {
+			pattern = h.Patterns[i].Copy()
+		}
+		n.append(key, pattern)
+	}
+	return n
+}
+
+// BindNode holds a pattern whose match is bound to a name, as
+// Integer => n.
+type BindNode struct {
+	NodeType
+	Pos
+	Pattern Node   // The pattern to match.
+	Name    string // The variable that receives the matched value.
+}
+
+func newBind(pos Pos, pattern Node, name string) *BindNode {
+	return &BindNode{NodeType: NodeBind, Pos: pos, Pattern: pattern, Name: name}
+}
+
+func (b *BindNode) String() string {
+	return b.Pattern.String() + " => " + b.Name
+}
+
+func (b *BindNode) Copy() Node {
+	return newBind(b.Pos, b.Pattern.Copy(), b.Name)
+}
+
+// elseNode represents an else keyword.
+// It does not appear in the final parse tree.
+type elseNode struct {
+	NodeType
+	Pos
+	Line int // The line number in the input.
+}
+
+func newElse(pos Pos, line int) *elseNode {
+	return &elseNode{NodeType: nodeElse, Pos: pos, Line: line}
+}
+
+func (e *elseNode) String() string {
+	return "else"
+}
+
+func (e *elseNode) Copy() Node {
+	return newElse(e.Pos, e.Line)
+}
+
+// clauseNode represents a when or in keyword that starts a clause of a
+// case statement. It does not appear in the final parse tree.
+type clauseNode struct {
+	NodeType
+	Pos
+	Line    int    // The line number in the input.
+	Keyword string // when or in.
+}
+
+func newClause(pos Pos, line int, keyword string) *clauseNode {
+	return &clauseNode{NodeType: nodeClause, Pos: pos, Line: line, Keyword: keyword}
+}
+
+func (c *clauseNode) String() string {
+	return c.Keyword
+}
+
+func (c *clauseNode) Copy() Node {
+	return newClause(c.Pos, c.Line, c.Keyword)
+}
+
+// endNode represents an end keyword.
+// It does not appear in the final parse tree.
+type endNode struct {
+	NodeType
+	Pos
+	Line int // The line number in the input.
+}
+
+func newEnd(pos Pos, line int) *endNode {
+	return &endNode{NodeType: nodeEnd, Pos: pos, Line: line}
+}
+
+func (e *endNode) String() string {
+	return "end"
+}
+
+func (e *endNode) Copy() Node {
+	return newEnd(e.Pos, e.Line)
+}
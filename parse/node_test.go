@@ -0,0 +1,217 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package parse
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// shape describes a tree without the positions and line numbers that
+// printing is allowed to change.
+func shape(root Node) []string {
+	var s []string
+	Inspect(root, func(n Node) bool {
+		switch n := n.(type) {
+		case nil:
+			s = append(s, "end")
+		case *ListNode, *ActionNode, *CommandNode:
+			s = append(s, fmt.Sprintf("%T", n))
+		case *CaseNode, *WhenNode, *InNode:
+			s = append(s, fmt.Sprintf("%T", n))
+		case *DefNode:
+			s = append(s, fmt.Sprintf("%T %s", n, n.Name))
+		case *ForNode:
+			s = append(s, fmt.Sprintf("%T %s", n, n.Var))
+		default:
+			s = append(s, fmt.Sprintf("%T %s", n, n))
+		}
+		return n != nil
+	})
+	return s
+}
+
+// roundTrip checks that printing the tree parsed from src produces
+// source that parses to an equivalent tree and prints the same again.
+func roundTrip(t *testing.T, name, src string) {
+	treeSet, err := Parse(name, src)
+	if err != nil {
+		t.Errorf("%s: %s", name, err)
+		return
+	}
+	root := treeSet[name].Root
+	printed := root.String()
+
+	treeSet, err = Parse(name, printed)
+	if err != nil {
+		t.Errorf("%s: reparsing %q: %s", name, printed, err)
+		return
+	}
+	reparsed := treeSet[name].Root
+
+	if got, want := shape(reparsed), shape(root); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s: reparsing %q gave\n%v\nwant\n%v", name, printed, got, want)
+	}
+	if again := reparsed.String(); again != printed {
+		t.Errorf("%s: printing is not stable:\n%q\nthen\n%q", name, printed, again)
+	}
+}
+
+func TestStringRoundTripCorpus(t *testing.T) {
+	examples, err := filepath.Glob("../*.frb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(examples) == 0 {
+		t.Fatal("no example programs found")
+	}
+	// The golden corpus too, leaving out files that are expected to fail.
+	corpus, err := filepath.Glob("../testdata/*.frb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := examples
+	for _, file := range corpus {
+		if _, err := os.Stat(strings.TrimSuffix(file, ".frb") + ".err"); os.IsNotExist(err) {
+			files = append(files, file)
+		}
+	}
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		roundTrip(t, file, string(src))
+	}
+}
+
+var (
+	randomOperands    = []string{"puts", "x", "foo_bar", "Net", "true", "false", "nil", "0", "42", "-7", "3.25", "1e3", "0x1F", "2i", "1+2i", `""`, `"hi # there"`, "{}", `{k: 1, v: "x"}`, "g()", `f(1, *a, k: "x", **h)`, `'it\'s #{x}'`, ":sym", ":empty?", "Net::Http", "a==b", "x || y && z != 1", "s =~ /a b/"}
+	randomRegexps     = []string{"/fur+by/i", `/a\/b # c/x`, "/ /"} // only at the start of a command, where "/" cannot be division.
+	randomAssignments = []string{"x = 1", "a, b = b, a", "first, *rest = list", "(k, v), i = pair, 0", "*init, last = 1..3", "A,b=*c, :d"}
+	randomCases       = []string{
+		"case x\nwhen 1, *y then puts 1\nwhen 2\n  puts 2\nelse # other\nend",
+		"case\n\n# c\nwhen a\nend",
+		"case v\nin [a, *b] | {k:, l: 1..2} => m if a then m\nin []\n\n  puts\nend",
+	}
+	randomSpaces = []string{" ", "  ", "\t", " \t "}
+)
+
+// randomProgram returns a valid program made of commands, assignments,
+// case statements, comments, blank lines, loops and definitions, with
+// irregular spacing.
+func randomProgram(r *rand.Rand) string {
+	pick := func(list []string) string { return list[r.Intn(len(list))] }
+	comment := func() string {
+		return "#" + strings.Repeat(pick([]string{"", " ", "x", "# ", "é"}), r.Intn(5)) + pick([]string{"", " ", "\t"})
+	}
+
+	var b strings.Builder
+	var blocks []string // for or def, innermost last.
+	for n := r.Intn(8); n > 0 || len(blocks) > 0; n-- {
+		if r.Intn(3) == 0 {
+			b.WriteString(pick(randomSpaces))
+		}
+		switch r.Intn(7) {
+		case 0:
+			// blank line
+		case 1:
+			b.WriteString(comment())
+		case 2:
+			if n > 0 && r.Intn(2) == 0 {
+				b.WriteString("for i in " + pick([]string{"list", "1..3", "0...n", ":a..:z"}))
+				blocks = append(blocks, "for")
+				break
+			}
+			if n > 0 {
+				b.WriteString("def " + pick([]string{"f", "empty?", "f()", "f(a)", "f(a, b = 2, *rest, key:, opt: :x, **opts, &blk)"}))
+				blocks = append(blocks, "def")
+				break
+			}
+			fallthrough
+		case 3:
+			if len(blocks) > 0 {
+				word := "end"
+				if blocks[len(blocks)-1] == "for" {
+					word = pick([]string{"end", "break", "next 1", "redo"})
+				}
+				if word == "end" {
+					blocks = blocks[:len(blocks)-1]
+				}
+				b.WriteString(word)
+				break
+			}
+			fallthrough
+		case 4:
+			if r.Intn(3) == 0 {
+				b.WriteString(pick(randomCases))
+				break
+			}
+			b.WriteString(pick(randomAssignments))
+		default:
+			first := true
+			for args := 1 + r.Intn(4); args > 0; args-- {
+				if first && r.Intn(4) == 0 {
+					b.WriteString(pick(randomRegexps))
+				} else {
+					b.WriteString(pick(randomOperands))
+				}
+				first = false
+				if args > 1 || r.Intn(2) == 0 {
+					b.WriteString(pick(randomSpaces))
+				}
+			}
+			if r.Intn(3) == 0 {
+				b.WriteString(comment())
+			}
+		}
+		b.WriteString(pick([]string{"\n", "\n\n", "\r\n"}))
+	}
+	return b.String()
+}
+
+func TestStringRoundTripRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		roundTrip(t, fmt.Sprintf("random%d", i), randomProgram(r))
+	}
+}
+
+func TestRegexpFlags(t *testing.T) {
+	tests := []struct {
+		literal, match, noMatch string
+	}{
+		{"/fur+by/", "furrby", "FURBY"},
+		{"/fur+by/i", "FURBY", "fuby"},
+		{"/a.b/", "a-b", "a\nb"},
+		{"/a.b/m", "a\nb", "ab"},
+		{"/a b # c/x", "ab", "a b"},
+		{`/a\ b/x`, "a b", "ab"},
+		{"/[# ]/x", " ", "a"},
+		{"/a[^ #] # c/x", "ab", "a "},
+		{`/[\] ]+ b/x`, "] ]b", "] ]\tb"},
+		{"/[] a]+ b/x", "] ]b", "b"},
+		{"/[[:space:]#]x/x", "#x", "ax"},
+	}
+	for _, test := range tests {
+		r, err := newRegexp(0, test.literal)
+		if err != nil {
+			t.Errorf("%s: %s", test.literal, err)
+			continue
+		}
+		if !r.Regexp.MatchString(test.match) {
+			t.Errorf("%s does not match %q", test.literal, test.match)
+		}
+		if r.Regexp.MatchString(test.noMatch) {
+			t.Errorf("%s matches %q", test.literal, test.noMatch)
+		}
+	}
+}
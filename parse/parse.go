@@ -7,6 +7,9 @@ package parse
 import (
 	"fmt"
 	"runtime"
+	"strings"
+
+	"github.com/carlosbrando/furby/scanner"
 )
 
 // Tree is the representation of a single parsed template.
@@ -17,10 +20,12 @@ type Tree struct {
 	text      string    // text parsed to create the template (or its parent)
 	// Parsing only; cleared after parse.
 	funcs     []map[string]interface{}
-	lex       *lexer
-	token     [3]item // three-token lookahead for parser.
+	lex       *scanner.Scanner
+	token     [3]scanner.Token // three-token lookahead for parser.
 	peekCount int
-	vars      []string // variables defined at the moment.
+	vars      []string       // variables defined at the moment.
+	loopDepth int            // nesting level of for loops.
+	reuse     func(Pos) bool // reports whether a previous tree can be reused from a top-level statement on.
 }
 
 // Parse returns a map from template name to parse.Tree, created by parsing the
@@ -42,7 +47,7 @@ func Parse(name, text string, funcs ...map[string]interface{}) (treeSet map[stri
 func (t *Tree) Parse(text string, treeSet map[string]*Tree, funcs ...map[string]interface{}) (tree *Tree, err error) {
 	defer t.recover(&err)
 	t.ParseName = t.Name
-	t.startParse(funcs, lex(t.Name, text))
+	t.startParse(funcs, scanner.New(t.Name, text))
 	t.text = text
 	t.parse(treeSet)
 	t.add(treeSet)
@@ -67,7 +72,9 @@ func IsEmptyTree(n Node) bool {
 	switch n := n.(type) {
 	case nil:
 		return true
-	case *ActionNode:
+	case *ActionNode, *AssignNode, *CaseNode, *DefNode, *ForNode:
+	case *CommentNode:
+		return true
 	// case *IfNode:
 	case *ListNode:
 		for _, node := range n.Nodes {
@@ -88,11 +95,11 @@ func IsEmptyTree(n Node) bool {
 }
 
 // next returns the next token.
-func (t *Tree) next() item {
+func (t *Tree) next() scanner.Token {
 	if t.peekCount > 0 {
 		t.peekCount--
 	} else {
-		t.token[0] = t.lex.nextItem()
+		t.token[0] = t.lex.NextToken()
 	}
 	return t.token[t.peekCount]
 }
@@ -102,27 +109,49 @@ func (t *Tree) backup() {
 	t.peekCount++
 }
 
+// backup2 backs the input stream up two tokens.
+// The zeroth token is already there.
+func (t *Tree) backup2(t1 scanner.Token) {
+	t.token[1] = t1
+	t.peekCount = 2
+}
+
+// backup3 backs the input stream up three tokens.
+// The zeroth token is already there.
+func (t *Tree) backup3(t2, t1 scanner.Token) { // Reverse order: we're pushing back.
+	t.token[1] = t1
+	t.token[2] = t2
+	t.peekCount = 3
+}
+
 // peek returns but does not consume the next token.
-func (t *Tree) peek() item {
+func (t *Tree) peek() scanner.Token {
 	if t.peekCount > 0 {
 		return t.token[t.peekCount-1]
 	}
 	t.peekCount = 1
-	t.token[0] = t.lex.nextItem()
+	t.token[0] = t.lex.NextToken()
 	return t.token[0]
 }
 
 // nextNonSpace returns the next non-space token.
-func (t *Tree) nextNonSpace() (token item) {
+func (t *Tree) nextNonSpace() (token scanner.Token) {
 	for {
 		token = t.next()
-		if token.typ != itemSpace {
+		if token.Kind != scanner.Space {
 			break
 		}
 	}
 	return token
 }
 
+// peekNonSpace returns but does not consume the next non-space token.
+func (t *Tree) peekNonSpace() (token scanner.Token) {
+	token = t.nextNonSpace()
+	t.backup()
+	return token
+}
+
 // Parsing.
 
 // New allocates a new parse tree with the given name.
@@ -133,11 +162,34 @@ func New(name string, funcs ...map[string]interface{}) *Tree {
 	}
 }
 
+// Error is the error returned by Parse. It records where in the input
+// the problem was found so tools can point at it.
+type Error struct {
+	Name string // name of the input being parsed.
+	Line int    // line of the offending token, starting at 1.
+	Pos  Pos    // byte position of the offending token.
+	Msg  string // description of the problem.
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("template: %s:%d: %s", e.Name, e.Line, e.Msg)
+}
+
 // errorf formats the error and terminates processing.
 func (t *Tree) errorf(format string, args ...interface{}) {
 	t.Root = nil
-	format = fmt.Sprintf("template: %s:%d: %s", t.ParseName, t.lex.lineNumber(), format)
-	panic(fmt.Errorf(format, args...))
+	last := t.lex.Last()
+	panic(&Error{
+		Name: t.ParseName,
+		Line: last.Line,
+		Pos:  Pos(last.Pos),
+		Msg:  fmt.Sprintf(format, args...),
+	})
+}
+
+// error terminates processing.
+func (t *Tree) error(err error) {
+	t.errorf("%s", err)
 }
 
 // recover is the handler that turns panics into returns from the top level of Parse.
@@ -156,11 +208,12 @@ func (t *Tree) recover(errp *error) {
 }
 
 // startParse initializes the parser, using the lexer.
-func (t *Tree) startParse(funcs []map[string]interface{}, lex *lexer) {
+func (t *Tree) startParse(funcs []map[string]interface{}, lex *scanner.Scanner) {
 	t.Root = nil
 	t.lex = lex
 	t.vars = []string{"$"}
 	t.funcs = funcs
+	t.loopDepth = 0
 }
 
 // stopParse terminates parsing.
@@ -168,14 +221,23 @@ func (t *Tree) stopParse() {
 	t.lex = nil
 	t.vars = nil
 	t.funcs = nil
+	t.reuse = nil
 }
 
 // parse is the top-level parser for a template, essentially the same
 // as itemList except it also parses {{define}} actions.
 // It runs to EOF.
 func (t *Tree) parse(treeSet map[string]*Tree) (next Node) {
-	t.Root = newList(t.peek().pos)
-	for t.peek().typ != itemEOF {
+	t.Root = newList(Pos(t.peek().Pos))
+	for t.peek().Kind != scanner.EOF {
+		switch t.peek().Kind {
+		case scanner.Space, scanner.EndOfLine:
+			t.next()
+			continue
+		}
+		if t.reuse != nil && t.reuse(Pos(t.peek().Pos)) {
+			break
+		}
 		// if t.peek().typ == itemLeftDelim {
 		// 	delim := t.next()
 		// 	if t.nextNonSpace().typ == itemDefine {
@@ -189,8 +251,9 @@ func (t *Tree) parse(treeSet map[string]*Tree) (next Node) {
 		// 	t.backup2(delim)
 		// }
 		// n := t.textOrAction()
-		n := t.action()
-		if n.Type() == nodeEnd {
+		n := t.statement()
+		switch n.Type() {
+		case nodeEnd, nodeElse, nodeClause:
 			t.errorf("unexpected %s", n)
 		}
 		t.Root.append(n)
@@ -198,27 +261,881 @@ func (t *Tree) parse(treeSet map[string]*Tree) (next Node) {
 	return nil
 }
 
+// itemList:
+//  statement*
+// Terminates at end, else, when or in, returned separately.
+func (t *Tree) itemList() (list *ListNode, next Node) {
+	list = newList(Pos(t.peekNonSpace().Pos))
+	for t.peekNonSpace().Kind != scanner.EOF {
+		if t.peekNonSpace().Kind == scanner.EndOfLine {
+			t.next()
+			continue
+		}
+		n := t.statement()
+		switch n.Type() {
+		case nodeEnd, nodeElse, nodeClause:
+			return list, n
+		}
+		list.append(n)
+	}
+	t.errorf("unexpected EOF")
+	return
+}
+
+// statement parses a comment or an action, which is everything but
+// blank lines. The next token is not a space.
+func (t *Tree) statement() Node {
+	if token := t.peek(); token.Kind == scanner.Comment {
+		t.next()
+		return newComment(Pos(token.Pos), token.Line, token.Val)
+	}
+	return t.action()
+}
+
 // Action:
 //  control
 //  command ("|" command)*
 // Left delim is past. Now get actions.
 // First word could be a keyword such as range.
 func (t *Tree) action() (n Node) {
-	switch token := t.nextNonSpace(); token.typ {
-	// case itemElse:
-	// 	return t.elseControl()
-	// case itemEnd:
-	// 	return t.endControl()
+	switch token := t.nextNonSpace(); token.Kind {
+	case scanner.End:
+		return newEnd(Pos(token.Pos), token.Line)
+	case scanner.Else:
+		return newElse(Pos(token.Pos), token.Line)
+	case scanner.When, scanner.In:
+		return newClause(Pos(token.Pos), token.Line, token.Val)
+	case scanner.Case:
+		return t.caseControl(token)
+	case scanner.Def:
+		return t.defControl(token)
+	case scanner.For:
+		return t.forControl(token)
+	case scanner.Break, scanner.Next, scanner.Redo:
+		return t.branchControl(token)
 	// case itemIf:
 	// 	return t.ifControl()
-	// case itemRange:
-	// 	return t.rangeControl()
 	// case itemTemplate:
 	// 	return t.templateControl()
 	// case itemWith:
 	// 	return t.withControl()
 	}
 	t.backup()
+	if t.isAssignment() {
+		return t.assign()
+	}
 	// Do not pop variables; they persist until "end".
-	return newAction(t.peek().pos, t.lex.lineNumber())
+	return newAction(Pos(t.peek().Pos), t.peek().Line, t.command())
+}
+
+// isAssignment reports whether the statement starting at the next token
+// is an assignment. It looks at most three tokens ahead: a splat or a
+// parenthesis, or a name followed by = or a comma.
+func (t *Tree) isAssignment() bool {
+	first := t.next()
+	switch {
+	case isChar(first, "*"), isChar(first, "("):
+		t.backup()
+		return true
+	case first.Kind != scanner.Identifier && first.Kind != scanner.Constant:
+		t.backup()
+		return false
+	}
+	second := t.next()
+	if second.Kind != scanner.Space {
+		t.backup2(first)
+		return isChar(second, "=") || isChar(second, ",")
+	}
+	third := t.next()
+	t.backup3(first, second)
+	return isChar(third, "=") || isChar(third, ",")
+}
+
+// isChar reports whether token is the single character c.
+func isChar(token scanner.Token, c string) bool {
+	return token.Kind == scanner.Char && token.Val == c
+}
+
+// Assignment:
+//  target (, target)* = value (, value)*
+func (t *Tree) assign() Node {
+	start := t.peek()
+	targets := t.targets(false)
+	values := t.values("assignment")
+	t.endOfStatement("assignment")
+	return newAssign(Pos(start.Pos), start.Line, targets, values)
+}
+
+// Values:
+//  value (, value)*
+// where a value is an expression or a *operand splat.
+func (t *Tree) values(context string) (values []Node) {
+	for {
+		t.peekNonSpace()
+		if token := t.peek(); isChar(token, "*") {
+			t.next()
+			value := t.operand()
+			if value == nil {
+				t.errorf("missing value after * in %s", context)
+			}
+			values = append(values, newSplat(Pos(token.Pos), value))
+		} else {
+			value := t.expression()
+			if value == nil {
+				t.errorf("missing value in %s", context)
+			}
+			values = append(values, value)
+		}
+		if !isChar(t.peekNonSpace(), ",") {
+			return values
+		}
+		t.next()
+	}
+}
+
+// Targets:
+//  target (, target)*
+// where a target is a name, a *name taking the remaining values, or a
+// parenthesized list of targets. A list has at most one splat. The list
+// ends at = at the top level and at ) when nested; the terminator is
+// consumed.
+func (t *Tree) targets(nested bool) (targets []Node) {
+	splat := false
+	for {
+		var target Node
+		switch token := t.nextNonSpace(); {
+		case token.Kind == scanner.Identifier, token.Kind == scanner.Constant:
+			target = newIdentifier(Pos(token.Pos), token.Val)
+		case isChar(token, "*"):
+			name := t.next()
+			if name.Kind != scanner.Identifier {
+				t.errorf("unexpected %s in assignment: expected name after *", name)
+			}
+			if splat {
+				t.errorf("more than one splat in assignment")
+			}
+			splat = true
+			target = newSplat(Pos(token.Pos), newIdentifier(Pos(name.Pos), name.Val))
+		case isChar(token, "("):
+			target = newGroup(Pos(token.Pos), t.targets(true))
+		case token.Kind == scanner.Error:
+			t.errorf("%s", token.Val)
+		default:
+			t.errorf("unexpected %s in assignment", token)
+		}
+		targets = append(targets, target)
+
+		switch token := t.nextNonSpace(); {
+		case isChar(token, ","):
+		case nested && isChar(token, ")"), !nested && isChar(token, "="):
+			return targets
+		case token.Kind == scanner.Error:
+			t.errorf("%s", token.Val)
+		default:
+			t.errorf("unexpected %s in assignment", token)
+		}
+	}
+}
+
+// endOfStatement checks that nothing but a comment follows on the line
+// of the statement named by context.
+func (t *Tree) endOfStatement(context string) {
+	switch token := t.peekNonSpace(); token.Kind {
+	case scanner.EndOfLine, scanner.Comment, scanner.EOF:
+	case scanner.Error:
+		t.errorf("%s", token.Val)
+	default:
+		t.errorf("unexpected %s in %s", token, context)
+	}
+}
+
+// unexpected complains about the token and terminates processing.
+func (t *Tree) unexpected(token scanner.Token, context string) {
+	if token.Kind == scanner.Error {
+		t.errorf("%s", token.Val)
+	}
+	t.errorf("unexpected %s in %s", token, context)
+}
+
+// endOf checks that next, which ended the body of a statement named by
+// context, is an end keyword alone on its line, and returns its line.
+func (t *Tree) endOf(context string, next Node) int {
+	end, ok := next.(*endNode)
+	if !ok {
+		t.errorf("unexpected %s in %s", next, context)
+	}
+	t.endOfStatement("end")
+	return end.Line
+}
+
+// For:
+//  for identifier in expression
+//    statement*
+//  end
+// For keyword is past.
+func (t *Tree) forControl(loop scanner.Token) Node {
+	name := t.nextNonSpace()
+	if name.Kind != scanner.Identifier {
+		t.errorf("unexpected %s in for: expected variable name", name)
+	}
+	if token := t.nextNonSpace(); token.Kind != scanner.In {
+		t.errorf("unexpected %s in for: expected in", token)
+	}
+	t.peekNonSpace()
+	collection := t.expression()
+	if collection == nil {
+		t.errorf("missing value to iterate over in for")
+	}
+	t.endOfStatement("for")
+
+	t.loopDepth++
+	list, next := t.itemList()
+	t.loopDepth--
+	return newFor(Pos(loop.Pos), loop.Line, t.endOf("for", next), name.Val, collection, list)
+}
+
+// Def:
+//  def name
+//  def name(param (, param)*)
+//    statement*
+//  end
+// Def keyword is past.
+func (t *Tree) defControl(def scanner.Token) Node {
+	name := t.nextNonSpace()
+	if name.Kind != scanner.Identifier && name.Kind != scanner.Constant {
+		t.errorf("unexpected %s in def: expected method name", name)
+	}
+	method := name.Val
+	if token := t.peek(); token.Kind == scanner.Char && (token.Val == "?" || token.Val == "!") {
+		t.next()
+		method += token.Val
+	}
+	var params []*ParamNode
+	if token := t.peek(); token.Kind == scanner.Char && token.Val == "(" {
+		t.next()
+		params = t.params()
+	}
+	t.endOfStatement("def")
+
+	// Loops around the definition don't extend into the method.
+	depth := t.loopDepth
+	t.loopDepth = 0
+	list, next := t.itemList()
+	t.loopDepth = depth
+	return newDef(Pos(def.Pos), def.Line, t.endOf("def", next), method, params, list)
+}
+
+// paramRank orders the kinds of parameters: positional ones come
+// first, then keywords, the keyword splat and the block.
+var paramRank = map[ParamKind]int{
+	ParamRequired:    0,
+	ParamOptional:    0,
+	ParamRest:        0,
+	ParamKey:         1,
+	ParamKeyOptional: 1,
+	ParamKeyRest:     2,
+	ParamBlock:       3,
+}
+
+// params parses the parameters of a definition up to the closing
+// parenthesis, which is consumed. The opening one is past.
+func (t *Tree) params() (params []*ParamNode) {
+	if token := t.peekNonSpace(); token.Kind == scanner.Char && token.Val == ")" {
+		t.next()
+		return nil
+	}
+	seen := make(map[string]bool)
+	// rest is the *rest parameter and post the first required parameter
+	// after an optional one or rest. Neither may be followed by an
+	// optional parameter or a rest.
+	var rest, optional, post *ParamNode
+	for {
+		p := t.param()
+		if seen[p.Name] {
+			t.errorf("duplicate parameter %s", p.Name)
+		}
+		seen[p.Name] = true
+		if len(params) > 0 {
+			prev := params[len(params)-1]
+			switch r := paramRank[p.Kind]; {
+			case r < paramRank[prev.Kind], r >= 2 && r == paramRank[prev.Kind]:
+				t.errorf("parameter %s after %s", p, prev)
+			case rest != nil && (p.Kind == ParamOptional || p.Kind == ParamRest):
+				t.errorf("parameter %s after %s", p, rest)
+			case post != nil && (p.Kind == ParamOptional || p.Kind == ParamRest):
+				t.errorf("parameter %s after %s", p, post)
+			}
+		}
+		switch {
+		case p.Kind == ParamRest:
+			rest = p
+		case p.Kind == ParamOptional:
+			optional = p
+		case p.Kind == ParamRequired && post == nil && (optional != nil || rest != nil):
+			post = p
+		}
+		params = append(params, p)
+
+		switch token := t.nextNonSpace(); {
+		case token.Kind == scanner.Char && token.Val == ",":
+		case token.Kind == scanner.Char && token.Val == ")":
+			return params
+		case token.Kind == scanner.Error:
+			t.errorf("%s", token.Val)
+		default:
+			t.errorf("unexpected %s in parameter list", token)
+		}
+	}
+}
+
+// Param:
+//  name
+//  name = expression
+//  *name
+//  name:
+//  name: expression
+//  **name
+//  &name
+func (t *Tree) param() *ParamNode {
+	token := t.nextNonSpace()
+	kind := ParamRequired
+	switch {
+	case token.Kind == scanner.Label:
+		name := strings.TrimSuffix(token.Val, ":")
+		t.peekNonSpace()
+		if value := t.expression(); value != nil {
+			return newParam(Pos(token.Pos), ParamKeyOptional, name, value)
+		}
+		return newParam(Pos(token.Pos), ParamKey, name, nil)
+	case token.Kind == scanner.Char && token.Val == "*":
+		kind = ParamRest
+	case token.Kind == scanner.Operator && token.Val == "**":
+		kind = ParamKeyRest
+	case token.Kind == scanner.Char && token.Val == "&":
+		kind = ParamBlock
+	default:
+		t.backup()
+	}
+	name := t.next()
+	if name.Kind != scanner.Identifier {
+		t.errorf("unexpected %s in parameter list: expected parameter name", name)
+	}
+	if kind == ParamRequired {
+		if eq := t.peekNonSpace(); eq.Kind == scanner.Char && eq.Val == "=" {
+			t.next()
+			t.peekNonSpace()
+			value := t.expression()
+			if value == nil {
+				t.errorf("missing default value for parameter %s", name.Val)
+			}
+			return newParam(Pos(name.Pos), ParamOptional, name.Val, value)
+		}
+	}
+	return newParam(Pos(token.Pos), kind, name.Val, nil)
+}
+
+// Case:
+//  case [expression]
+//    (when value (, value)* [then]
+//      statement*)+
+//    [else
+//      statement*]
+//  end
+// or the same with in clauses:
+//    in pattern [if expression | unless expression] [then]
+// The clauses of a case are all of the same kind. Case keyword is past.
+func (t *Tree) caseControl(token scanner.Token) Node {
+	c := newCase(Pos(token.Pos), token.Line, nil)
+	switch t.peekNonSpace().Kind {
+	case scanner.EndOfLine, scanner.Comment, scanner.EOF:
+	default:
+		if c.Subject = t.expression(); c.Subject == nil {
+			t.unexpected(t.next(), "case")
+		}
+	}
+	t.endOfStatement("case")
+
+	c.Comments = newList(Pos(t.peekNonSpace().Pos))
+	for {
+		token := t.nextNonSpace()
+		switch token.Kind {
+		case scanner.EndOfLine:
+			continue
+		case scanner.Comment:
+			c.Comments.append(newComment(Pos(token.Pos), token.Line, token.Val))
+			continue
+		case scanner.When, scanner.In:
+		default:
+			t.unexpected(token, "case: expected when or in")
+		}
+		t.backup()
+		break
+	}
+
+	first := t.statement().(*clauseNode)
+	var next Node = first
+	for {
+		clause, ok := next.(*clauseNode)
+		if !ok {
+			break
+		}
+		if clause.Keyword != first.Keyword {
+			t.errorf("%s clause in case with %s clauses", clause, first)
+		}
+		var list *ListNode
+		if clause.Keyword == "when" {
+			values := t.values("when")
+			t.then("when")
+			list, next = t.itemList()
+			c.Clauses = append(c.Clauses, newWhen(clause.Pos, clause.Line, values, list))
+			continue
+		}
+		pattern := t.pattern()
+		var guard Node
+		unless := false
+		switch token := t.peekNonSpace(); token.Kind {
+		case scanner.If, scanner.Unless:
+			t.next()
+			unless = token.Kind == scanner.Unless
+			t.peekNonSpace()
+			if guard = t.expression(); guard == nil {
+				t.unexpected(t.next(), "guard")
+			}
+		}
+		t.then("in")
+		list, next = t.itemList()
+		c.Clauses = append(c.Clauses, newIn(clause.Pos, clause.Line, pattern, guard, unless, list))
+	}
+	if e, ok := next.(*elseNode); ok {
+		t.endOfStatement("else")
+		c.ElseLine = e.Line
+		c.Else, next = t.itemList()
+	}
+	c.EndLine = t.endOf("case", next)
+	return c
+}
+
+// then consumes the then keyword that may follow the header of a
+// clause. Without it, the header must end its line.
+func (t *Tree) then(context string) {
+	if t.peekNonSpace().Kind == scanner.Then {
+		t.next()
+		return
+	}
+	t.endOfStatement(context)
+}
+
+// Pattern:
+//  alternative [=> identifier]
+// Alternative:
+//  primary (| primary)*
+func (t *Tree) pattern() Node {
+	start := t.peekNonSpace()
+	p := t.primaryPattern()
+	if isChar(t.peekNonSpace(), "|") {
+		patterns := []Node{p}
+		for isChar(t.peekNonSpace(), "|") {
+			t.next()
+			patterns = append(patterns, t.primaryPattern())
+		}
+		p = newAlternative(Pos(start.Pos), patterns)
+	}
+	if token := t.peekNonSpace(); token.Kind == scanner.Operator && token.Val == "=>" {
+		t.next()
+		name := t.nextNonSpace()
+		if name.Kind != scanner.Identifier {
+			t.unexpected(name, "pattern: expected name after =>")
+		}
+		p = newBind(Pos(start.Pos), p, name.Val)
+	}
+	return p
+}
+
+// Primary:
+//  [ [elem (, elem)*] ]
+//  { [label [pattern] (, label [pattern])*] }
+//  operand
+// where an elem is a pattern or a *identifier splat, at most one per
+// array.
+func (t *Tree) primaryPattern() Node {
+	token := t.nextNonSpace()
+	switch {
+	case isChar(token, "["):
+		a := newArrayPattern(Pos(token.Pos), nil)
+		if isChar(t.peekNonSpace(), "]") {
+			t.next()
+			return a
+		}
+		splat := false
+		for {
+			if star := t.peekNonSpace(); isChar(star, "*") {
+				t.next()
+				name := t.next()
+				if name.Kind != scanner.Identifier {
+					t.unexpected(name, "pattern: expected name after *")
+				}
+				if splat {
+					t.errorf("more than one splat in pattern")
+				}
+				splat = true
+				a.Elems = append(a.Elems, newSplat(Pos(star.Pos), newIdentifier(Pos(name.Pos), name.Val)))
+			} else {
+				a.Elems = append(a.Elems, t.pattern())
+			}
+			switch token := t.nextNonSpace(); {
+			case isChar(token, ","):
+			case isChar(token, "]"):
+				return a
+			default:
+				t.unexpected(token, "pattern")
+			}
+		}
+	case isChar(token, "{"):
+		h := newHashPattern(Pos(token.Pos))
+		if isChar(t.peekNonSpace(), "}") {
+			t.next()
+			return h
+		}
+		seen := make(map[string]bool)
+		for {
+			label := t.nextNonSpace()
+			if label.Kind != scanner.Label {
+				t.unexpected(label, "pattern: expected key")
+			}
+			key := strings.TrimSuffix(label.Val, ":")
+			if seen[key] {
+				t.errorf("duplicate key %s in pattern", key)
+			}
+			seen[key] = true
+			var pattern Node
+			if next := t.peekNonSpace(); !isChar(next, ",") && !isChar(next, "}") {
+				pattern = t.pattern()
+			}
+			h.append(key, pattern)
+			switch token := t.nextNonSpace(); {
+			case isChar(token, ","):
+			case isChar(token, "}"):
+				return h
+			default:
+				t.unexpected(token, "pattern")
+			}
+		}
+	}
+	t.backup()
+	p := t.operand()
+	if p == nil {
+		t.unexpected(t.next(), "pattern")
+	}
+	return p
+}
+
+// Branch:
+//  break [expression]
+//  next [expression]
+//  redo
+// Keyword is past.
+func (t *Tree) branchControl(keyword scanner.Token) Node {
+	if t.loopDepth == 0 {
+		t.errorf("%s outside for", keyword.Val)
+	}
+	var value Node
+	if keyword.Kind != scanner.Redo {
+		t.peekNonSpace()
+		value = t.expression()
+	}
+	t.endOfStatement(keyword.Val)
+	return newBranch(Pos(keyword.Pos), keyword.Line, keyword.Val, value)
+}
+
+// Command:
+//  expression (space expression)*
+// space-separated arguments up to the end of the line, a comment or EOF.
+func (t *Tree) command() *CommandNode {
+	cmd := newCommand(Pos(t.peekNonSpace().Pos))
+	for {
+		t.peekNonSpace() // skip leading spaces.
+		arg := t.expression()
+		if arg != nil {
+			cmd.append(arg)
+		}
+		switch token := t.next(); token.Kind {
+		case scanner.Space:
+			continue
+		case scanner.Error:
+			t.errorf("%s", token.Val)
+		case scanner.EndOfLine, scanner.Comment, scanner.EOF:
+			t.backup()
+		default:
+			t.errorf("unexpected %s in operand", token)
+		}
+		break
+	}
+	if len(cmd.Args) == 0 {
+		t.errorf("empty command")
+	}
+	return cmd
+}
+
+// binaryPrecedence gives the binary operators, which bind tighter the
+// higher their precedence. Operators of the same precedence associate
+// to the left.
+var binaryPrecedence = map[string]int{
+	"||":  1,
+	"&&":  2,
+	"==":  3,
+	"!=":  3,
+	"===": 3,
+	"=~":  3,
+	"!~":  3,
+	"<=":  4,
+	">=":  4,
+	"+":   sumPrecedence,
+	"-":   sumPrecedence,
+}
+
+// sumPrecedence is the precedence of + and -. They bind tighter than the
+// range operators, so 0-1..n-1 is the range from 0-1 to n-1, and the other
+// binary operators looser, so a..b == c compares the range with c.
+const sumPrecedence = 5
+
+// Expression:
+//  operand (operator operand)*
+// where the operators are the ones in binaryPrecedence. Spaces around
+// an operator don't separate the arguments of a command. A sign right
+// before a number is the number's where the scanner expects an operand,
+// so 'puts a -1' has two arguments but 'puts 1 -1' and 'x = a - 1' one.
+func (t *Tree) expression() Node {
+	return t.binary(1)
+}
+
+// binary parses an expression whose operators all have at least the
+// given precedence. From sumPrecedence on, its operands are terms.
+func (t *Tree) binary(precedence int) Node {
+	var left Node
+	if precedence < sumPrecedence {
+		left = t.operand()
+	} else {
+		left = t.term()
+	}
+	if left == nil {
+		return nil
+	}
+	for {
+		op, ok := t.binaryOperator(precedence)
+		if !ok {
+			return left
+		}
+		t.peekNonSpace()
+		right := t.binary(binaryPrecedence[op.Val] + 1)
+		if right == nil {
+			t.unexpected(t.next(), "expression: expected operand after "+op.Val)
+		}
+		left = newBinary(left.Position(), op.Val, left, right)
+	}
+}
+
+// binaryOperator consumes the next binary operator and the space before
+// it if its precedence is at least the given one. Otherwise it consumes
+// nothing.
+func (t *Tree) binaryOperator(precedence int) (op scanner.Token, ok bool) {
+	isOperator := func(token scanner.Token) bool {
+		return (token.Kind == scanner.Operator || token.Kind == scanner.Char) && binaryPrecedence[token.Val] >= precedence
+	}
+	op = t.next()
+	if op.Kind != scanner.Space {
+		if isOperator(op) {
+			return op, true
+		}
+		t.backup()
+		return op, false
+	}
+	space := op
+	if op = t.next(); isOperator(op) {
+		return op, true
+	}
+	t.backup2(space)
+	return op, false
+}
+
+// Operand:
+//  sum
+//  sum .. sum
+//  sum ... sum
+// where a sum is an expression of terms and the operators + and -. An
+// operand is a space-separated component of a command.
+func (t *Tree) operand() Node {
+	low := t.binary(sumPrecedence)
+	if low == nil {
+		return nil
+	}
+	op := t.peek()
+	if op.Kind != scanner.Operator || (op.Val != ".." && op.Val != "...") {
+		return low
+	}
+	t.next()
+	high := t.binary(sumPrecedence)
+	if high == nil {
+		t.errorf("missing end of range after %s%s", low, op.Val)
+	}
+	return newRange(low.Position(), low, high, op.Val == "...")
+}
+
+// Term:
+//  literal (number, string, regexp, symbol, nil, boolean)
+//  hash
+//  identifier
+//  constant
+//  term::constant
+//  call
+func (t *Tree) term() Node {
+	switch token := t.next(); token.Kind {
+	case scanner.Identifier, scanner.Constant:
+		var n Node = newIdentifier(Pos(token.Pos), token.Val)
+		for op := t.peek(); op.Kind == scanner.Operator && op.Val == "::"; op = t.peek() {
+			t.next()
+			name := t.next()
+			if name.Kind != scanner.Constant {
+				t.unexpected(name, "scope: expected constant after ::")
+			}
+			n = newScope(Pos(token.Pos), n, name.Val)
+		}
+		if isChar(t.peek(), "(") {
+			return t.call(n)
+		}
+		return n
+	case scanner.Nil:
+		return newNil(Pos(token.Pos))
+	case scanner.True, scanner.False:
+		return newBool(Pos(token.Pos), token.Kind == scanner.True)
+	case scanner.String:
+		text, err := unquote(token.Val)
+		if err != nil {
+			t.error(err)
+		}
+		return newString(Pos(token.Pos), token.Val, text)
+	case scanner.Symbol:
+		return newSymbol(Pos(token.Pos), token.Val[1:])
+	case scanner.Char:
+		if token.Val == "{" {
+			return t.hash(token)
+		}
+	case scanner.Regexp:
+		re, err := newRegexp(Pos(token.Pos), token.Val)
+		if err != nil {
+			t.error(err)
+		}
+		return re
+	case scanner.Number, scanner.Complex:
+		number, err := newNumber(Pos(token.Pos), token.Val, token.Kind)
+		if err != nil {
+			t.error(err)
+		}
+		return number
+	}
+	t.backup()
+	return nil
+}
+
+// Hash:
+//  { [label expression (, label expression)*] }
+// Left brace is past.
+func (t *Tree) hash(brace scanner.Token) Node {
+	h := newHash(Pos(brace.Pos))
+	if isChar(t.peekNonSpace(), "}") {
+		t.next()
+		return h
+	}
+	seen := make(map[string]bool)
+	for {
+		label := t.nextNonSpace()
+		if label.Kind != scanner.Label {
+			t.unexpected(label, "hash: expected key")
+		}
+		key := strings.TrimSuffix(label.Val, ":")
+		if seen[key] {
+			t.errorf("duplicate key %s in hash", key)
+		}
+		seen[key] = true
+		t.peekNonSpace()
+		value := t.expression()
+		if value == nil {
+			t.unexpected(t.next(), "hash: expected value for "+key)
+		}
+		h.append(key, value)
+		switch token := t.nextNonSpace(); {
+		case isChar(token, ","):
+		case isChar(token, "}"):
+			return h
+		default:
+			t.unexpected(token, "hash")
+		}
+	}
+}
+
+// Call:
+//  name( [arg (, arg)*] )
+// where an arg is a value, a *operand splat, a label value keyword
+// argument or a **operand keyword splat. Keyword arguments and keyword
+// splats come after the others. The parenthesis follows the name
+// without a space; it is next.
+func (t *Tree) call(fn Node) Node {
+	t.next()
+	var args []Node
+	if isChar(t.peekNonSpace(), ")") {
+		t.next()
+		return newCall(fn.Position(), fn, args)
+	}
+	seen := make(map[string]bool)
+	var keyword Node // the first keyword argument or keyword splat.
+	for {
+		var arg Node
+		switch token := t.nextNonSpace(); {
+		case isChar(token, "*"), token.Kind == scanner.Operator && token.Val == "**":
+			value := t.operand()
+			if value == nil {
+				t.unexpected(t.next(), "call: expected value after "+token.Val)
+			}
+			if token.Val == "*" {
+				arg = newSplat(Pos(token.Pos), value)
+			} else {
+				arg = newKeySplat(Pos(token.Pos), value)
+			}
+		case token.Kind == scanner.Label:
+			name := strings.TrimSuffix(token.Val, ":")
+			if seen[name] {
+				t.errorf("duplicate keyword argument %s", name)
+			}
+			seen[name] = true
+			t.peekNonSpace()
+			value := t.expression()
+			if value == nil {
+				t.unexpected(t.next(), "call: expected value for "+name)
+			}
+			arg = newKeyword(Pos(token.Pos), name, value)
+		default:
+			t.backup()
+			if arg = t.expression(); arg == nil {
+				t.unexpected(t.next(), "call")
+			}
+		}
+		switch arg.Type() {
+		case NodeKeyword, NodeKeySplat:
+			if keyword == nil {
+				keyword = arg
+			}
+		default:
+			if keyword != nil {
+				t.errorf("argument %s after %s", arg, keyword)
+			}
+		}
+		args = append(args, arg)
+
+		switch token := t.nextNonSpace(); {
+		case isChar(token, ","):
+		case isChar(token, ")"):
+			return newCall(fn.Position(), fn, args)
+		default:
+			t.unexpected(token, "call")
+		}
+	}
 }
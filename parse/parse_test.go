@@ -0,0 +1,96 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package parse
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// Golden tests share the corpus in the repository's testdata directory.
+// For every file.frb, file.ast holds the JSON encoding of its tree or,
+// if it doesn't parse, file.err holds the error.
+
+// parseTimeout bounds each parse so that a hang fails the test instead
+// of blocking the whole run.
+const parseTimeout = 5 * time.Second
+
+// golden returns the name and content of the golden file for src.
+func golden(t *testing.T, name, src string) (string, []byte) {
+	type result struct {
+		treeSet map[string]*Tree
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		treeSet, err := Parse(name, src)
+		done <- result{treeSet, err}
+	}()
+
+	var r result
+	select {
+	case r = <-done:
+	case <-time.After(parseTimeout):
+		t.Fatalf("%s: parse did not finish in %v", name, parseTimeout)
+	}
+
+	if r.err != nil {
+		return ".err", []byte(r.err.Error() + "\n")
+	}
+	data, err := json.MarshalIndent(r.treeSet[name].Root, "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ".ast", append(data, '\n')
+}
+
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob("../testdata/*.frb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no .frb files in ../testdata")
+	}
+
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ext, got := golden(t, filepath.Base(file), string(src))
+
+		base := strings.TrimSuffix(file, ".frb")
+		if *update {
+			// Only one of the two goldens may exist for a file.
+			for _, stale := range []string{".ast", ".err"} {
+				if stale != ext {
+					os.Remove(base + stale)
+				}
+			}
+			if err := ioutil.WriteFile(base+ext, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(base + ext)
+		if err != nil {
+			t.Errorf("%s (run go test -update to create it)", err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: got\n%s\nwant\n%s", file, got, want)
+		}
+	}
+}
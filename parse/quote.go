@@ -0,0 +1,115 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+// Quoting of string literals.
+
+package parse
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// escapes maps the character after a backslash in a quoted string to
+// the character the pair stands for.
+var escapes = map[byte]byte{
+	'"':  '"',
+	'\\': '\\',
+	'#':  '#',
+	'a':  '\a',
+	'b':  '\b',
+	'e':  0x1b,
+	'f':  '\f',
+	'n':  '\n',
+	'r':  '\r',
+	's':  ' ',
+	't':  '\t',
+	'v':  '\v',
+}
+
+// controlEscapes maps the control characters that have an escape to the
+// character that follows the backslash in it.
+var controlEscapes = map[byte]byte{
+	'\a': 'a',
+	'\b': 'b',
+	0x1b: 'e',
+	'\f': 'f',
+	'\n': 'n',
+	'\r': 'r',
+	'\t': 't',
+	'\v': 'v',
+}
+
+// unsupportedEscapes lists the characters that start an escape with an
+// argument, such as \x41, \u00e9 or \012. They are not implemented and
+// are rejected rather than read as the bare character.
+const unsupportedEscapes = "01234567xucCM"
+
+// unquote returns the value of the quoted string s, which the scanner
+// has checked to be closed on its line. As in Ruby, a backslash before
+// a character without a meaning of its own stands for that character.
+// In single quotes only \\ and \' are escapes and other backslashes are
+// kept.
+func unquote(s string) (string, error) {
+	single := s[0] == '\''
+	s = s[1 : len(s)-1]
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		if single {
+			if s[i+1] == '\\' || s[i+1] == '\'' {
+				i++
+			}
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		c = s[i]
+		if r, ok := escapes[c]; ok {
+			b.WriteByte(r)
+			continue
+		}
+		if strings.IndexByte(unsupportedEscapes, c) >= 0 {
+			return "", fmt.Errorf("unsupported escape sequence \\%c", c)
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), nil
+}
+
+// quote returns text in double quotes, the canonical form of a string.
+// Quotes, backslashes and control characters with an escape of their
+// own are escaped, and so is a # that would start an interpolation.
+func quote(text string) string {
+	var b bytes.Buffer
+	b.WriteByte('"')
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '#':
+			if i+1 < len(text) && strings.IndexByte("{$@", text[i+1]) >= 0 {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(c)
+		default:
+			if e, ok := controlEscapes[c]; ok {
+				b.WriteByte('\\')
+				c = e
+			}
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,151 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+// Incremental parsing.
+
+package parse
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/carlosbrando/furby/scanner"
+)
+
+// Reparse returns the tree for the text t was parsed from with edit
+// applied, or the error parsing it, exactly as if the new text were
+// parsed from scratch. t must come from a successful Parse or Reparse.
+//
+// Only the top-level statements on the lines the edit touches are
+// scanned and parsed again. The statements before them are shared with
+// t. The parse stops as soon as it reaches the start of one of the
+// statements after them, be it a command, a definition, a loop or a case
+// statement: from there on the text and the parser's state are the same
+// as before, and the rest of t is reused. Those statements are moved by
+// the edit in place rather than copied, so t must not be used after a
+// successful Reparse. If parsing fails, t is left as it was.
+func (t *Tree) Reparse(edit scanner.Edit) (tree *Tree, err error) {
+	start, end := edit.Lines(t.text)
+	dpos, dline := edit.Delta(t.text)
+	nodes := t.Root.Nodes
+
+	// The statements before i end before the edited lines. The parse
+	// starts again at the beginning of the first line holding an edit or
+	// a statement from i on.
+	i := sort.Search(len(nodes), func(i int) bool { return int(nodes[i].Position()) >= start })
+	from := start
+	for i > 0 && t.end(nodes[i-1]) > from {
+		i--
+		from = strings.LastIndex(t.text[:nodes[i].Position()], "\n") + 1
+	}
+	line := 1
+	if i == 0 {
+		from = 0 // only blank lines before; the root starts at the first token
+	} else {
+		_, last := lines(nodes[i-1])
+		line = last + 1 + strings.Count(t.text[t.end(nodes[i-1]):from], "\n")
+	}
+
+	// The statements from j on are the ones after the edit that the parse
+	// reached.
+	j := len(nodes)
+	text := edit.Apply(t.text)
+	nt := New(t.Name)
+	nt.ParseName = t.ParseName
+	defer nt.recover(&err)
+	nt.startParse(nil, scanner.NewAt(t.Name, text, from, line))
+	nt.text = text
+	nt.reuse = func(pos Pos) bool {
+		old := int(pos) - dpos
+		if old < end {
+			return false
+		}
+		j = sort.Search(len(nodes), func(j int) bool { return int(nodes[j].Position()) >= old })
+		return j < len(nodes) && int(nodes[j].Position()) == old
+	}
+	nt.parse(nil)
+	nt.stopParse()
+
+	if i > 0 {
+		nt.Root.Pos = t.Root.Pos
+	}
+	if dpos != 0 || dline != 0 {
+		for _, n := range nodes[j:] {
+			shift(n, dpos, dline)
+		}
+	}
+	nt.Root.Nodes = splice(nodes, i, j, nt.Root.Nodes)
+	return nt, nil
+}
+
+// end returns the position just past the line break that ends the last
+// line of the top-level statement n, or the length of the text if that
+// line is the last one.
+func (t *Tree) end(n Node) int {
+	first, last := lines(n)
+	pos := int(n.Position())
+	for ; first <= last; first++ {
+		i := strings.Index(t.text[pos:], "\n")
+		if i < 0 {
+			return len(t.text)
+		}
+		pos += i + 1
+	}
+	return pos
+}
+
+// splice returns nodes with nodes[i:j] replaced by middle. It reuses the
+// array of nodes when middle fits in it.
+func splice(nodes []Node, i, j int, middle []Node) []Node {
+	n := i + len(middle) + len(nodes) - j
+	if n > cap(nodes) {
+		out := make([]Node, 0, n)
+		out = append(out, nodes[:i]...)
+		out = append(out, middle...)
+		return append(out, nodes[j:]...)
+	}
+	out := nodes[:n]
+	copy(out[i+len(middle):], nodes[j:])
+	copy(out[i:], middle)
+	return out
+}
+
+// shift moves every position in n by dpos and every line number by
+// dline.
+func shift(n Node, dpos, dline int) {
+	Inspect(n, func(n Node) bool {
+		switch n := n.(type) {
+		case nil:
+			return false
+		case *ActionNode:
+			n.Line += dline
+		case *AssignNode:
+			n.Line += dline
+		case *BranchNode:
+			n.Line += dline
+		case *CaseNode:
+			n.Line += dline
+			n.EndLine += dline
+			if n.ElseLine != 0 {
+				n.ElseLine += dline
+			}
+		case *CommentNode:
+			n.Line += dline
+		case *DefNode:
+			n.Line += dline
+			n.EndLine += dline
+		case *ForNode:
+			n.Line += dline
+			n.EndLine += dline
+		case *InNode:
+			n.Line += dline
+		case *WhenNode:
+			n.Line += dline
+		}
+		n.(interface {
+			shift(Pos)
+		}).shift(Pos(dpos))
+		return true
+	})
+}
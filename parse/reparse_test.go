@@ -0,0 +1,148 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package parse
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/carlosbrando/furby/scanner"
+)
+
+// result describes the outcome of parsing: the JSON tree with positions
+// and lines, or the error.
+func result(t *testing.T, tree *Tree, err error) string {
+	if err != nil {
+		e := err.(*Error)
+		data, _ := json.Marshal(e)
+		return "error " + string(data)
+	}
+	data, jerr := json.Marshal(tree.Root)
+	if jerr != nil {
+		t.Fatal(jerr)
+	}
+	return string(data)
+}
+
+var editSnippets = []string{"", "puts", " ", "\n", "\n\n", "x ", "42", "# note", "nil", `"s"`, `"`, "end", "::", "12abc", "\t"}
+
+func randomEdit(r *rand.Rand, text string) scanner.Edit {
+	start := r.Intn(len(text) + 1)
+	end := start + r.Intn(len(text)-start+1)
+	if r.Intn(2) == 0 {
+		// Mostly small edits, as when typing.
+		end = start + r.Intn(3)
+		if end > len(text) {
+			end = len(text)
+		}
+	}
+	return scanner.Edit{Start: start, End: end, New: editSnippets[r.Intn(len(editSnippets))]}
+}
+
+func TestReparseRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		text := randomProgram(r)
+		tree, err := New("edit").Parse(text, make(map[string]*Tree))
+		if err != nil {
+			t.Fatalf("%q: %s", text, err)
+		}
+		// Keep editing while the text stays valid.
+		for tree != nil {
+			edit := randomEdit(r, text)
+			got, gotErr := tree.Reparse(edit)
+			text = edit.Apply(text)
+			want, wantErr := New("edit").Parse(text, make(map[string]*Tree))
+			if g, w := result(t, got, gotErr), result(t, want, wantErr); g != w {
+				t.Fatalf("reparsing %q after %+v:\ngot  %s\nwant %s", text, edit, g, w)
+			}
+			tree = got
+		}
+	}
+}
+
+func TestReparseReuses(t *testing.T) {
+	const text = "puts 1\nputs 2 # two\ndef f(a)\n\tfor i in a\n\t\tputs i\n\tend\nend\n"
+	tree, err := New("reuse").Parse(text, make(map[string]*Tree))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := append([]Node(nil), tree.Root.Nodes...)
+
+	// A syntax error leaves the tree as it was.
+	if _, err := tree.Reparse(scanner.Edit{Start: 12, End: 13, New: "2 ::"}); err == nil {
+		t.Fatal("no error for a bad edit")
+	}
+	if def := old[3].(*DefNode); def.Position() != 20 || def.Line != 3 {
+		t.Fatalf("a failed reparse moved the definition: %+v", def)
+	}
+
+	// Replace the 2 with 22 and a new line.
+	got, err := tree.Reparse(scanner.Edit{Start: 12, End: 13, New: "22\nputs 3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Root.Nodes[0] != old[0] {
+		t.Error("the statement before the edit was not reused")
+	}
+	if got.Root.Nodes[1] == old[1] || got.Root.Nodes[2] == old[2] {
+		t.Error("the edited statements were reused")
+	}
+	if got.Root.Nodes[4] != old[3] {
+		t.Error("the definition after the edit was not reused")
+	}
+	def := got.Root.Nodes[4].(*DefNode)
+	inner := def.List.Nodes[0].(*ForNode).List.Nodes[0].(*ActionNode)
+	if def.Position() != 28 || def.Line != 4 || def.EndLine != 8 || inner.Line != 6 || inner.Cmd.Args[1].Position() != 56 {
+		t.Errorf("the definition after the edit was not moved: %+v", def)
+	}
+	want := "puts 1\nputs 22\nputs 3 # two\ndef f(a)\n\tfor i in a\n\t\tputs i\n\tend\nend"
+	if got.Root.String() != want {
+		t.Errorf("got %q, want %q", got.Root.String(), want)
+	}
+}
+
+// benchProgram is a large program of definitions, loops, case statements
+// and commands, 12000 lines long.
+var benchProgram = strings.Repeat(`# greet prints a greeting.
+def greet(name, greeting = "hello")
+	for i in 1..3
+		puts greeting name i
+	end
+end
+x = 42
+case x
+when 1 then puts :one
+else
+	puts "other"
+end
+`, 1000)
+
+func BenchmarkParse(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := New("bench").Parse(benchProgram, make(map[string]*Tree)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkReparse types and deletes a digit in the middle of
+// benchProgram, one edit per iteration.
+func BenchmarkReparse(b *testing.B) {
+	tree, err := New("bench").Parse(benchProgram, make(map[string]*Tree))
+	if err != nil {
+		b.Fatal(err)
+	}
+	at := len(benchProgram)/2 + strings.Index(benchProgram[len(benchProgram)/2:], "42")
+	edits := []scanner.Edit{{Start: at, End: at, New: "1"}, {Start: at, End: at + 1}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if tree, err = tree.Reparse(edits[i%2]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package parse
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children
+// of node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses a parse tree in depth-first order: It starts by calling
+// v.Visit(node); node must not be nil. If the visitor w returned by
+// v.Visit(node) is not nil, Walk is invoked recursively with visitor
+// w for each of the non-nil children of node, followed by a call of
+// w.Visit(nil).
+func Walk(node Node, v Visitor) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *ListNode:
+		for _, c := range n.Nodes {
+			Walk(c, v)
+		}
+	case *ActionNode:
+		if n.Cmd != nil {
+			Walk(n.Cmd, v)
+		}
+	case *CommandNode:
+		for _, arg := range n.Args {
+			Walk(arg, v)
+		}
+	case *AssignNode:
+		for _, target := range n.Targets {
+			Walk(target, v)
+		}
+		for _, value := range n.Values {
+			Walk(value, v)
+		}
+	case *GroupNode:
+		for _, target := range n.Targets {
+			Walk(target, v)
+		}
+	case *SplatNode:
+		Walk(n.Node, v)
+	case *CaseNode:
+		if n.Subject != nil {
+			Walk(n.Subject, v)
+		}
+		Walk(n.Comments, v)
+		for _, clause := range n.Clauses {
+			Walk(clause, v)
+		}
+		if n.Else != nil {
+			Walk(n.Else, v)
+		}
+	case *WhenNode:
+		for _, value := range n.Values {
+			Walk(value, v)
+		}
+		Walk(n.List, v)
+	case *InNode:
+		Walk(n.Pattern, v)
+		if n.Guard != nil {
+			Walk(n.Guard, v)
+		}
+		Walk(n.List, v)
+	case *AlternativeNode:
+		for _, p := range n.Patterns {
+			Walk(p, v)
+		}
+	case *ArrayPatternNode:
+		for _, elem := range n.Elems {
+			Walk(elem, v)
+		}
+	case *HashNode:
+		for _, value := range n.Values {
+			Walk(value, v)
+		}
+	case *HashPatternNode:
+		for _, p := range n.Patterns {
+			if p != nil {
+				Walk(p, v)
+			}
+		}
+	case *BindNode:
+		Walk(n.Pattern, v)
+	case *DefNode:
+		for _, p := range n.Params {
+			Walk(p, v)
+		}
+		Walk(n.List, v)
+	case *ParamNode:
+		if n.Default != nil {
+			Walk(n.Default, v)
+		}
+	case *ForNode:
+		Walk(n.Collection, v)
+		Walk(n.List, v)
+	case *RangeNode:
+		Walk(n.Low, v)
+		Walk(n.High, v)
+	case *BinaryNode:
+		Walk(n.Left, v)
+		Walk(n.Right, v)
+	case *ScopeNode:
+		Walk(n.Scope, v)
+	case *CallNode:
+		Walk(n.Func, v)
+		for _, arg := range n.Args {
+			Walk(arg, v)
+		}
+	case *KeywordNode:
+		Walk(n.Value, v)
+	case *KeySplatNode:
+		Walk(n.Node, v)
+	case *BranchNode:
+		if n.Value != nil {
+			Walk(n.Value, v)
+		}
+	case *BoolNode, *CommentNode, *IdentifierNode, *NilNode, *NumberNode, *RegexpNode, *StringNode, *SymbolNode:
+		// nothing to do
+	default:
+		panic("parse.Walk: unexpected node type " + n.String())
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses a parse tree in depth-first order: It starts by
+// calling f(node); node must not be nil. If f returns true, Inspect
+// invokes f recursively for each of the non-nil children of node,
+// followed by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(node, inspector(f))
+}
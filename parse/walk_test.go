@@ -0,0 +1,60 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package parse
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const walkInput = "puts 2 # two\nputs true nil\n"
+
+func TestInspect(t *testing.T) {
+	treeSet, err := Parse("walk", walkInput)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	Inspect(treeSet["walk"].Root, func(n Node) bool {
+		if n == nil {
+			got = append(got, "nil")
+			return false
+		}
+		got = append(got, nodeNames[n.Type()])
+		// Don't descend into the second command.
+		return n.Position() != 13 || n.Type() != NodeAction
+	})
+
+	want := []string{
+		"List",
+		"Action", "Command", "Identifier", "nil", "Number", "nil", "nil", "nil",
+		"Comment", "nil",
+		"Action",
+		"nil",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Inspect visited\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	treeSet, err := Parse("json", "puts 2 # two\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.Marshal(treeSet["json"].Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"nodes":[` +
+		`{"cmd":{"args":[{"ident":"puts","pos":0,"type":"Identifier"},{"pos":5,"text":"2","type":"Number"}],"pos":0,"type":"Command"},"line":1,"pos":0,"type":"Action"},` +
+		`{"line":1,"pos":7,"text":"# two","type":"Comment"}` +
+		`],"pos":0,"type":"List"}`
+	if string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// benchInput is a large generated program using every kind of token.
+var benchInput = strings.Repeat("puts 42 -7 3.25 1+2i \"hello\" Net::Http x == y(z) # note\nif true nil end\n", 10000)
+
+// channelScan drives the scanner the way it ran before NextToken: in its
+// own goroutine, handing each token over an unbuffered channel.
+func channelScan(input string) int {
+	tokens := make(chan Token)
+	go func() {
+		s := New("bench", input)
+		for {
+			token := s.NextToken()
+			tokens <- token
+			if token.Kind == EOF || token.Kind == Error {
+				close(tokens)
+				return
+			}
+		}
+	}()
+	n := 0
+	for range tokens {
+		n++
+	}
+	return n
+}
+
+func pullScan(input string) int {
+	s := New("bench", input)
+	for n := 1; ; n++ {
+		if token := s.NextToken(); token.Kind == EOF || token.Kind == Error {
+			return n
+		}
+	}
+}
+
+// benchmarkScan runs scan over benchInput and reports throughput and
+// allocations per token.
+func benchmarkScan(b *testing.B, scan func(string) int) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	tokens := 0
+	for i := 0; i < b.N; i++ {
+		tokens += scan(benchInput)
+	}
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+
+	b.ReportMetric(float64(tokens)/b.Elapsed().Seconds(), "tokens/s")
+	b.ReportMetric(float64(after.Mallocs-before.Mallocs)/float64(tokens), "allocs/token")
+	b.SetBytes(int64(len(benchInput)))
+}
+
+func BenchmarkNextToken(b *testing.B) {
+	benchmarkScan(b, pullScan)
+}
+
+func BenchmarkChannel(b *testing.B) {
+	benchmarkScan(b, channelScan)
+}
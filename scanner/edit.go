@@ -0,0 +1,91 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package scanner
+
+import "strings"
+
+// An Edit replaces the bytes of a text from Start up to End with New.
+type Edit struct {
+	Start, End int
+	New        string
+}
+
+// Apply returns text with the edit applied.
+func (e Edit) Apply(text string) string {
+	return text[:e.Start] + e.New + text[e.End:]
+}
+
+// Lines returns the span of text made of the whole lines the edit
+// touches: from the start of the line holding Start to just past the
+// line break ending the line that holds End.
+func (e Edit) Lines(text string) (start, end int) {
+	start = strings.LastIndex(text[:e.Start], "\n") + 1
+	end = len(text)
+	if i := strings.Index(text[e.End:], "\n"); i >= 0 {
+		end = e.End + i + 1
+	}
+	return
+}
+
+// Delta returns how far the edit moves the bytes and lines of text that
+// follow it.
+func (e Edit) Delta(text string) (pos, lines int) {
+	pos = len(e.New) - (e.End - e.Start)
+	lines = strings.Count(e.New, "\n") - strings.Count(text[e.Start:e.End], "\n")
+	return
+}
+
+// Rescan returns the tokens of text with e applied, given tokens, the
+// result of scanning text up to and including its EOF or Error token.
+//
+// The scanner is in the same state at the start of every line, so only
+// the lines the edit touches are scanned again. The tokens before them
+// are kept as they are and the tokens after them are moved by the edit's
+// delta. The result is the same as scanning the new text from scratch.
+func Rescan(text string, tokens []Token, e Edit) []Token {
+	start, end := e.Lines(text)
+	dpos, dline := e.Delta(text)
+	newText := e.Apply(text)
+
+	var out []Token
+	i := 0
+	for ; i < len(tokens) && tokens[i].Pos < start; i++ {
+		out = append(out, tokens[i])
+		if tokens[i].Kind == Error {
+			// The scan stopped before reaching the edit.
+			return out
+		}
+	}
+	for i < len(tokens) && tokens[i].Pos < end {
+		i++
+	}
+	after := tokens[i:]
+
+	// If the old scan stopped inside the edited lines, there is nothing
+	// after them to reuse and the new text is scanned to its end.
+	newEnd := end + dpos
+	if len(after) == 0 {
+		newEnd = len(newText) + 1
+	}
+
+	s := NewAt("", newText, start, 1+strings.Count(newText[:start], "\n"))
+	for {
+		token := s.NextToken()
+		if token.Pos >= newEnd {
+			break
+		}
+		out = append(out, token)
+		if token.Kind == EOF || token.Kind == Error {
+			return out
+		}
+	}
+
+	for _, token := range after {
+		token.Pos += dpos
+		token.Line += dline
+		out = append(out, token)
+	}
+	return out
+}
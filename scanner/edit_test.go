@@ -0,0 +1,81 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// scanAll returns every token of text up to and including EOF or Error.
+func scanAll(text string) []Token {
+	var tokens []Token
+	s := New("", text)
+	for {
+		token := s.NextToken()
+		tokens = append(tokens, token)
+		if token.Kind == EOF || token.Kind == Error {
+			return tokens
+		}
+	}
+}
+
+var editPieces = []string{"puts", " ", "  ", "\n", "\n", "\r\n", "x", "Net", "::", "==", "=", "-", "+", "12", "1+2i", "3.5", "12abc", `"`, `"s"`, "# c", "end", "\t", "é"}
+
+// randomText joins random pieces, which may well not scan.
+func randomText(r *rand.Rand, pieces int) string {
+	var b strings.Builder
+	for ; pieces > 0; pieces-- {
+		b.WriteString(editPieces[r.Intn(len(editPieces))])
+	}
+	return b.String()
+}
+
+// randomEdit returns an edit of text with random bounds and replacement.
+func randomEdit(r *rand.Rand, text string) Edit {
+	start := r.Intn(len(text) + 1)
+	end := start + r.Intn(len(text)-start+1)
+	return Edit{Start: start, End: end, New: randomText(r, r.Intn(4))}
+}
+
+func TestRescanRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		text := randomText(r, r.Intn(30))
+		tokens := scanAll(text)
+		// Apply a few edits in a row, rescanning each time.
+		for j := 0; j < 3; j++ {
+			edit := randomEdit(r, text)
+			got := Rescan(text, tokens, edit)
+			text = edit.Apply(text)
+			tokens = scanAll(text)
+			if !reflect.DeepEqual(got, tokens) {
+				t.Fatalf("rescanning %q after %+v:\ngot\n\t%+v\nwant\n\t%+v", text, edit, got, tokens)
+			}
+		}
+	}
+}
+
+func TestEditLines(t *testing.T) {
+	const text = "ab\ncd\nef"
+	tests := []struct {
+		edit       Edit
+		start, end int
+	}{
+		{Edit{0, 0, "x"}, 0, 3},
+		{Edit{4, 4, "x"}, 3, 6},
+		{Edit{1, 4, ""}, 0, 6},
+		{Edit{7, 8, "\n"}, 6, 8},
+		{Edit{8, 8, "x"}, 6, 8},
+		{Edit{3, 3, "x"}, 3, 6},
+	}
+	for _, test := range tests {
+		if start, end := test.edit.Lines(text); start != test.start || end != test.end {
+			t.Errorf("%+v.Lines(%q) = %d, %d, want %d, %d", test.edit, text, start, end, test.start, test.end)
+		}
+	}
+}
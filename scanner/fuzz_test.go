@@ -0,0 +1,70 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fuzzTimeout bounds a single scan so that a loop that makes no
+// progress is reported instead of stalling the fuzzer.
+const fuzzTimeout = 2 * time.Second
+
+func FuzzScan(f *testing.F) {
+	// Seed with the example programs and the golden corpus.
+	for _, pattern := range []string{"../*.frb", "../testdata/*.frb"} {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, file := range files {
+			src, err := ioutil.ReadFile(file)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(string(src))
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		done := make(chan []Token, 1)
+		go func() {
+			var tokens []Token
+			s := New("fuzz", input)
+			for {
+				token := s.NextToken()
+				tokens = append(tokens, token)
+				if token.Kind == Error || token.Kind == EOF {
+					done <- tokens
+					return
+				}
+			}
+		}()
+
+		var tokens []Token
+		select {
+		case tokens = <-done:
+		case <-time.After(fuzzTimeout):
+			t.Fatalf("scanning %q did not finish in %v", input, fuzzTimeout)
+		}
+
+		last := 0
+		for _, token := range tokens {
+			if token.Pos < last || token.Pos > len(input) {
+				t.Fatalf("scanning %q: token %v at %d, out of order or out of bounds", input, token, token.Pos)
+			}
+			last = token.Pos
+			if token.Kind == Error || token.Kind == EOF {
+				break
+			}
+			if end := token.Pos + len(token.Val); end > len(input) || input[token.Pos:end] != token.Val {
+				t.Fatalf("scanning %q: token %v does not match the input at %d", input, token, token.Pos)
+			}
+		}
+	})
+}
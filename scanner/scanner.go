@@ -0,0 +1,380 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+// Package scanner implements the scanner for Furby source text. It turns
+// the input into a stream of tokens that the parser and the tools consume.
+//
+// The scanner is a state machine driven on demand: each call to NextToken
+// runs state functions until one of them produces a token. No goroutine
+// or channel is involved, so an abandoned Scanner is simply garbage.
+package scanner
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const eof = -1
+
+// stateFn represents the state of the scanner as a function that returns the next state.
+type stateFn func(*Scanner) stateFn
+
+// Scanner holds the state of the scanner.
+type Scanner struct {
+	name  string  // the name of the input; used only for error reports
+	input string  // the string being scanned
+	state stateFn // the next scanning function to enter
+	pos   int     // current position in the input
+	start int     // start position of this token
+	width int     // width of last rune read from input
+	line  int     // line number at start
+	prev  Token   // last token emitted other than Space and Comment
+	token Token   // token produced by the last state function
+	ready bool    // token is waiting to be returned by NextToken
+	last  Token   // most recent token returned by NextToken
+}
+
+// New creates a new scanner for the input string.
+func New(name, input string) *Scanner {
+	return &Scanner{
+		name:  name,
+		input: input,
+		state: lexToken,
+		line:  1,
+		prev:  Token{Kind: EndOfLine},
+	}
+}
+
+// NewAt creates a new scanner for the input string that starts at pos,
+// the beginning of the given line. The scanner is in the same state at
+// the start of every line, so it returns the tokens a scanner created by
+// New would return from there on.
+func NewAt(name, input string, pos, line int) *Scanner {
+	s := New(name, input)
+	s.pos, s.start, s.line = pos, pos, line
+	return s
+}
+
+// NextToken returns the next token from the input, running the state
+// machine until it produces one. After EOF or an Error token it returns
+// the zero Token.
+func (s *Scanner) NextToken() Token {
+	for !s.ready {
+		if s.state == nil {
+			return Token{}
+		}
+		s.state = s.state(s)
+	}
+	s.ready = false
+	s.last = s.token
+	return s.token
+}
+
+// Last returns the most recent token returned by NextToken. Parsers use
+// it to report where they stopped.
+func (s *Scanner) Last() Token {
+	return s.last
+}
+
+// next returns the next rune in the input.
+func (s *Scanner) next() rune {
+	if s.pos >= len(s.input) {
+		s.width = 0
+		return eof
+	}
+	r, w := utf8.DecodeRuneInString(s.input[s.pos:])
+	s.width = w
+	s.pos += s.width
+	return r
+}
+
+// peek returns but does not consume the next rune in the input.
+func (s *Scanner) peek() rune {
+	r := s.next()
+	s.backup()
+	return r
+}
+
+// backup steps back one rune. Can only be called once per call of next.
+func (s *Scanner) backup() {
+	s.pos -= s.width
+}
+
+// emit passes a token back to the client. A state function emits at
+// most one token, so NextToken can return it as soon as the function
+// returns.
+func (s *Scanner) emit(k Kind) {
+	value := s.input[s.start:s.pos]
+	s.token = Token{k, s.start, s.line, value}
+	s.ready = true
+	if k != Space && k != Comment {
+		s.prev = s.token
+	}
+	s.line += strings.Count(value, "\n")
+	s.start = s.pos
+}
+
+// accept consumes the next rune if it's from the valid set.
+func (s *Scanner) accept(valid string) bool {
+	if strings.IndexRune(valid, s.next()) >= 0 {
+		return true
+	}
+	s.backup()
+	return false
+}
+
+// acceptRun consumes a run of runes from the valid set.
+func (s *Scanner) acceptRun(valid string) {
+	for strings.IndexRune(valid, s.next()) >= 0 {
+	}
+	s.backup()
+}
+
+// errorf returns an error token and terminates the scan by passing
+// back a nil pointer that will be the next state, terminating NextToken.
+func (s *Scanner) errorf(format string, args ...interface{}) stateFn {
+	s.token = Token{Error, s.start, s.line, fmt.Sprintf(format, args...)}
+	s.ready = true
+	return nil
+}
+
+// state functions
+
+// lexToken scans the next token.
+func lexToken(s *Scanner) stateFn {
+	switch r := s.next(); {
+	case r == eof:
+		s.emit(EOF)
+		return nil
+	case isEndOfLine(r):
+		s.emit(EndOfLine)
+	case isSpace(r):
+		return lexSpace
+	case r == '#':
+		return lexComment
+	case r == '"' || r == '\'':
+		return lexQuote
+	case r == '/' && s.operandExpected():
+		return lexRegexp
+	case r == ':' && isAlphaNumeric(s.peek()) && !unicode.IsDigit(s.peek()):
+		return lexSymbol
+	case (r == '+' || r == '-') && '0' <= s.peek() && s.peek() <= '9' && s.operandExpected():
+		s.backup()
+		return lexNumber
+	case '0' <= r && r <= '9':
+		s.backup()
+		return lexNumber
+	case isAlphaNumeric(r):
+		s.backup()
+		return lexIdentifier
+	default:
+		for _, op := range operators {
+			if strings.HasPrefix(s.input[s.start:], op) {
+				s.pos = s.start + len(op)
+				s.emit(Operator)
+				return lexToken
+			}
+		}
+		s.emit(Char)
+	}
+	return lexToken
+}
+
+// lexSpace scans a run of space characters.
+// One space has already been seen.
+func lexSpace(s *Scanner) stateFn {
+	for isSpace(s.peek()) {
+		s.next()
+	}
+	s.emit(Space)
+	return lexToken
+}
+
+// lexComment scans a comment. The comment marker is known to be present
+// and the comment runs to the end of the line, which is not included.
+// Trailing space is left out of the comment and scanned as Space.
+func lexComment(s *Scanner) stateFn {
+	end := s.pos
+	for r := s.next(); r != eof && !isEndOfLine(r); r = s.next() {
+		if !isSpace(r) {
+			end = s.pos
+		}
+	}
+	s.pos = end
+	s.emit(Comment)
+	return lexToken
+}
+
+// lexQuote scans a string in double or single quotes. The opening quote
+// is known to be present and the string must close on the same line. A
+// backslash escapes the character after it, so \" does not end the
+// string. The parser interprets the escapes.
+func lexQuote(s *Scanner) stateFn {
+	quote := rune(s.input[s.start])
+Loop:
+	for {
+		switch s.next() {
+		case '\\':
+			if r := s.next(); r != eof && !isEndOfLine(r) {
+				break
+			}
+			fallthrough
+		case eof, '\n', '\r':
+			return s.errorf("unterminated quoted string")
+		case quote:
+			break Loop
+		}
+	}
+	s.emit(String)
+	return lexToken
+}
+
+// operandExpected reports whether the character just read starts an
+// operand rather than being a binary operator: whether a '/' starts a
+// regular expression instead of a division, and a sign starts a number
+// instead of an addition or subtraction. It does after anything that
+// can't end an operand, such as an operator, an opening parenthesis, a
+// keyword or the start of a line. After an identifier it does only as a
+// command argument, with a space before the character and none after
+// it, as in 'puts /x/' or 'puts -1'.
+func (s *Scanner) operandExpected() bool {
+	switch s.prev.Kind {
+	case Identifier:
+		spaced := s.start > s.prev.Pos+len(s.prev.Val)
+		return spaced && !isSpace(s.peek())
+	case Constant, Number, Complex, String, Regexp, Symbol, End, False, Nil, True:
+		return false
+	case Char:
+		return !strings.Contains(")]}", s.prev.Val)
+	}
+	return true
+}
+
+// lexRegexp scans a regular expression literal and its flags. The
+// opening slash is known to be present and the literal must close on
+// the same line. A backslash escapes the character after it, so \/ does
+// not end the literal. The flags are checked by the parser.
+func lexRegexp(s *Scanner) stateFn {
+Loop:
+	for {
+		switch s.next() {
+		case '\\':
+			if r := s.next(); r != eof && !isEndOfLine(r) {
+				break
+			}
+			fallthrough
+		case eof, '\n', '\r':
+			return s.errorf("unterminated regular expression")
+		case '/':
+			break Loop
+		}
+	}
+	for isAlphaNumeric(s.peek()) {
+		s.next()
+	}
+	s.emit(Regexp)
+	return lexToken
+}
+
+// lexNumber scans a number: decimal, octal, hex, float, or imaginary. This
+// isn't a perfect number scanner - for instance it accepts "." and "0x0.2"
+// and "089" - but when it's wrong the input is invalid and the parser (via
+// strconv) will notice.
+func lexNumber(s *Scanner) stateFn {
+	if !s.scanNumber() {
+		return s.errorf("bad number syntax: %q", s.input[s.start:s.pos])
+	}
+	if rest := s.input[s.pos:]; len(rest) > 1 && (rest[0] == '+' || rest[0] == '-') && '0' <= rest[1] && rest[1] <= '9' {
+		// Complex: 1+2i. No spaces, must end in 'i'. Otherwise the sign
+		// is an operator, as in 1-2.
+		end := s.pos
+		if s.scanNumber() && s.input[s.pos-1] == 'i' {
+			s.emit(Complex)
+			return lexToken
+		}
+		s.pos = end
+	}
+	s.emit(Number)
+	return lexToken
+}
+
+func (s *Scanner) scanNumber() bool {
+	// Optional leading sign.
+	s.accept("+-")
+	// Is it hex?
+	digits := "0123456789"
+	if s.accept("0") && s.accept("xX") {
+		digits = "0123456789abcdefABCDEF"
+	}
+	s.acceptRun(digits)
+	// A dot followed by another one is a range operator, as in 1..10.
+	if !strings.HasPrefix(s.input[s.pos:], "..") && s.accept(".") {
+		s.acceptRun(digits)
+	}
+	if s.accept("eE") {
+		s.accept("+-")
+		s.acceptRun("0123456789")
+	}
+	// Is it imaginary?
+	s.accept("i")
+	// Next thing mustn't be alphanumeric.
+	if isAlphaNumeric(s.peek()) {
+		s.next()
+		return false
+	}
+	return true
+}
+
+// lexIdentifier scans an alphanumeric word: a keyword, a constant or an
+// identifier.
+func lexIdentifier(s *Scanner) stateFn {
+	for isAlphaNumeric(s.peek()) {
+		s.next()
+	}
+	word := s.input[s.start:s.pos]
+	first, _ := utf8.DecodeRuneInString(word)
+	switch {
+	case s.peek() == ':' && !strings.HasPrefix(s.input[s.pos:], "::"):
+		s.next()
+		s.emit(Label)
+	case Lookup(word).IsKeyword():
+		s.emit(Lookup(word))
+	case unicode.IsUpper(first):
+		s.emit(Constant)
+	default:
+		s.emit(Identifier)
+	}
+	return lexToken
+}
+
+// lexSymbol scans a symbol literal. The colon is known to be present
+// and to be followed by a letter or underscore. Like method names, a
+// symbol may end in ? or !.
+func lexSymbol(s *Scanner) stateFn {
+	for isAlphaNumeric(s.peek()) {
+		s.next()
+	}
+	if r := s.peek(); r == '?' || r == '!' {
+		s.next()
+	}
+	s.emit(Symbol)
+	return lexToken
+}
+
+// isSpace reports whether r is a space character.
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// isEndOfLine reports whether r is an end-of-line character.
+func isEndOfLine(r rune) bool {
+	return r == '\r' || r == '\n'
+}
+
+// isAlphaNumeric reports whether r is an alphabetic, digit, or underscore.
+func isAlphaNumeric(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,256 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+type scanTest struct {
+	name   string
+	input  string
+	tokens []Token
+}
+
+func mkToken(kind Kind, text string) Token {
+	return Token{Kind: kind, Val: text}
+}
+
+var (
+	tEOF   = mkToken(EOF, "")
+	tEOL   = mkToken(EndOfLine, "\n")
+	tSpace = mkToken(Space, " ")
+)
+
+var scanTests = []scanTest{
+	{"empty", "", []Token{tEOF}},
+	{"spaces", " \t ", []Token{mkToken(Space, " \t "), tEOF}},
+	{"command", "puts 2\n", []Token{mkToken(Identifier, "puts"), tSpace, mkToken(Number, "2"), tEOL, tEOF}},
+	{"keywords", "break case def else end false for if in module next nil redo then true unless when", []Token{
+		mkToken(Break, "break"), tSpace,
+		mkToken(Case, "case"), tSpace,
+		mkToken(Def, "def"), tSpace,
+		mkToken(Else, "else"), tSpace,
+		mkToken(End, "end"), tSpace,
+		mkToken(False, "false"), tSpace,
+		mkToken(For, "for"), tSpace,
+		mkToken(If, "if"), tSpace,
+		mkToken(In, "in"), tSpace,
+		mkToken(Module, "module"), tSpace,
+		mkToken(Next, "next"), tSpace,
+		mkToken(Nil, "nil"), tSpace,
+		mkToken(Redo, "redo"), tSpace,
+		mkToken(Then, "then"), tSpace,
+		mkToken(True, "true"), tSpace,
+		mkToken(Unless, "unless"), tSpace,
+		mkToken(When, "when"),
+		tEOF,
+	}},
+	{"constants", "Net::Http", []Token{mkToken(Constant, "Net"), mkToken(Operator, "::"), mkToken(Constant, "Http"), tEOF}},
+	{"module", "module Net::Http\nend", []Token{
+		mkToken(Module, "module"), tSpace, mkToken(Constant, "Net"), mkToken(Operator, "::"), mkToken(Constant, "Http"), tEOL,
+		mkToken(End, "end"),
+		tEOF,
+	}},
+	{"operators", "a==b||c!=d", []Token{
+		mkToken(Identifier, "a"), mkToken(Operator, "=="), mkToken(Identifier, "b"),
+		mkToken(Operator, "||"), mkToken(Identifier, "c"), mkToken(Operator, "!="), mkToken(Identifier, "d"),
+		tEOF,
+	}},
+	{"longest operator", "a===b=>c", []Token{
+		mkToken(Identifier, "a"), mkToken(Operator, "==="), mkToken(Identifier, "b"), mkToken(Operator, "=>"), mkToken(Identifier, "c"),
+		tEOF,
+	}},
+	{"chars", "f(x, -y)", []Token{
+		mkToken(Identifier, "f"), mkToken(Char, "("), mkToken(Identifier, "x"), mkToken(Char, ","), tSpace,
+		mkToken(Char, "-"), mkToken(Identifier, "y"), mkToken(Char, ")"),
+		tEOF,
+	}},
+	{"numbers", "1, -2 3.5e2 0x1F 2i 1+2i", []Token{
+		mkToken(Number, "1"), mkToken(Char, ","), tSpace,
+		mkToken(Number, "-2"), tSpace,
+		mkToken(Number, "3.5e2"), tSpace,
+		mkToken(Number, "0x1F"), tSpace,
+		mkToken(Number, "2i"), tSpace,
+		mkToken(Complex, "1+2i"),
+		tEOF,
+	}},
+	{"signs", "x = -1 f(+2) puts -3 a -4", []Token{
+		mkToken(Identifier, "x"), tSpace, mkToken(Char, "="), tSpace, mkToken(Number, "-1"), tSpace,
+		mkToken(Identifier, "f"), mkToken(Char, "("), mkToken(Number, "+2"), mkToken(Char, ")"), tSpace,
+		mkToken(Identifier, "puts"), tSpace, mkToken(Number, "-3"), tSpace,
+		mkToken(Identifier, "a"), tSpace, mkToken(Number, "-4"),
+		tEOF,
+	}},
+	{"subtraction", "1-2 a-1 1 -2 a - 1 (a)-1 x+1 1-2i", []Token{
+		mkToken(Number, "1"), mkToken(Char, "-"), mkToken(Number, "2"), tSpace,
+		mkToken(Identifier, "a"), mkToken(Char, "-"), mkToken(Number, "1"), tSpace,
+		mkToken(Number, "1"), tSpace, mkToken(Char, "-"), mkToken(Number, "2"), tSpace,
+		mkToken(Identifier, "a"), tSpace, mkToken(Char, "-"), tSpace, mkToken(Number, "1"), tSpace,
+		mkToken(Char, "("), mkToken(Identifier, "a"), mkToken(Char, ")"), mkToken(Char, "-"), mkToken(Number, "1"), tSpace,
+		mkToken(Identifier, "x"), mkToken(Char, "+"), mkToken(Number, "1"), tSpace,
+		mkToken(Complex, "1-2i"),
+		tEOF,
+	}},
+	{"ranges", "1..10 a...b 1.5..2", []Token{
+		mkToken(Number, "1"), mkToken(Operator, ".."), mkToken(Number, "10"), tSpace,
+		mkToken(Identifier, "a"), mkToken(Operator, "..."), mkToken(Identifier, "b"), tSpace,
+		mkToken(Number, "1.5"), mkToken(Operator, ".."), mkToken(Number, "2"),
+		tEOF,
+	}},
+	{"params", "(a, *b, **c, &d)", []Token{
+		mkToken(Char, "("), mkToken(Identifier, "a"), mkToken(Char, ","), tSpace,
+		mkToken(Char, "*"), mkToken(Identifier, "b"), mkToken(Char, ","), tSpace,
+		mkToken(Operator, "**"), mkToken(Identifier, "c"), mkToken(Char, ","), tSpace,
+		mkToken(Char, "&"), mkToken(Identifier, "d"), mkToken(Char, ")"),
+		tEOF,
+	}},
+	{"string", `puts "a # b"`, []Token{mkToken(Identifier, "puts"), tSpace, mkToken(String, `"a # b"`), tEOF}},
+	{"escapes", `puts "a\"b\\" "\n"`, []Token{
+		mkToken(Identifier, "puts"), tSpace, mkToken(String, `"a\"b\\"`), tSpace, mkToken(String, `"\n"`),
+		tEOF,
+	}},
+	{"single quotes", `'a"b' 'it\'s' '#{x}'`, []Token{
+		mkToken(String, `'a"b'`), tSpace, mkToken(String, `'it\'s'`), tSpace, mkToken(String, `'#{x}'`),
+		tEOF,
+	}},
+	{"comment", "x # note  \n", []Token{mkToken(Identifier, "x"), tSpace, mkToken(Comment, "# note"), mkToken(Space, "  "), tEOL, tEOF}},
+	{"regexp", `puts /a\/b+/ix`, []Token{mkToken(Identifier, "puts"), tSpace, mkToken(Regexp, `/a\/b+/ix`), tEOF}},
+	{"regexp after char", "f(/x/)", []Token{mkToken(Identifier, "f"), mkToken(Char, "("), mkToken(Regexp, "/x/"), mkToken(Char, ")"), tEOF}},
+	{"match", "s =~ /x/", []Token{mkToken(Identifier, "s"), tSpace, mkToken(Operator, "=~"), tSpace, mkToken(Regexp, "/x/"), tEOF}},
+	{"division", "a / b/2 4/2 (a)/b", []Token{
+		mkToken(Identifier, "a"), tSpace, mkToken(Char, "/"), tSpace,
+		mkToken(Identifier, "b"), mkToken(Char, "/"), mkToken(Number, "2"), tSpace,
+		mkToken(Number, "4"), mkToken(Char, "/"), mkToken(Number, "2"), tSpace,
+		mkToken(Char, "("), mkToken(Identifier, "a"), mkToken(Char, ")"), mkToken(Char, "/"), mkToken(Identifier, "b"),
+		tEOF,
+	}},
+	{"symbols", "f :name :empty? x/:a", []Token{
+		mkToken(Identifier, "f"), tSpace, mkToken(Symbol, ":name"), tSpace, mkToken(Symbol, ":empty?"), tSpace,
+		mkToken(Identifier, "x"), mkToken(Char, "/"), mkToken(Symbol, ":a"),
+		tEOF,
+	}},
+	{"labels", "f(name: :x, Net::Http)", []Token{
+		mkToken(Identifier, "f"), mkToken(Char, "("), mkToken(Label, "name:"), tSpace, mkToken(Symbol, ":x"), mkToken(Char, ","), tSpace,
+		mkToken(Constant, "Net"), mkToken(Operator, "::"), mkToken(Constant, "Http"), mkToken(Char, ")"),
+		tEOF,
+	}},
+	{"bad number", "12abc", []Token{mkToken(Error, `bad number syntax: "12a"`)}},
+	{"unterminated string", "\"abc\n", []Token{mkToken(Error, "unterminated quoted string")}},
+	{"escaped quote at end", `"abc\"`, []Token{mkToken(Error, "unterminated quoted string")}},
+	{"unterminated single quotes", "'abc\"", []Token{mkToken(Error, "unterminated quoted string")}},
+	{"unterminated regexp", "puts /abc\n", []Token{mkToken(Identifier, "puts"), tSpace, mkToken(Error, "unterminated regular expression")}},
+}
+
+// collect gathers the emitted tokens into a slice.
+func collect(t *scanTest) (tokens []Token) {
+	s := New(t.name, t.input)
+	for {
+		token := s.NextToken()
+		tokens = append(tokens, token)
+		if token.Kind == EOF || token.Kind == Error {
+			break
+		}
+	}
+	return
+}
+
+func equal(i1, i2 []Token, checkPos bool) bool {
+	if len(i1) != len(i2) {
+		return false
+	}
+	for k := range i1 {
+		if i1[k].Kind != i2[k].Kind || i1[k].Val != i2[k].Val {
+			return false
+		}
+		if checkPos && (i1[k].Pos != i2[k].Pos || i1[k].Line != i2[k].Line) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestScan(t *testing.T) {
+	for _, test := range scanTests {
+		tokens := collect(&test)
+		if !equal(tokens, test.tokens, false) {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%v", test.name, tokens, test.tokens)
+		}
+	}
+}
+
+func TestPos(t *testing.T) {
+	test := scanTest{"pos", "puts 2\n  x", []Token{
+		{Identifier, 0, 1, "puts"},
+		{Space, 4, 1, " "},
+		{Number, 5, 1, "2"},
+		{EndOfLine, 6, 1, "\n"},
+		{Space, 7, 2, "  "},
+		{Identifier, 9, 2, "x"},
+		{EOF, 10, 2, ""},
+	}}
+	tokens := collect(&test)
+	if !equal(tokens, test.tokens, true) {
+		t.Errorf("got\n\t%+v\nexpected\n\t%+v", tokens, test.tokens)
+	}
+}
+
+// Golden tests share the corpus in the repository's testdata directory.
+// For every file.frb, file.tokens holds the tokens the scanner returns
+// for it, up to its EOF or Error token, one "KIND value line" per line.
+
+func dumpTokens(name, input string) []byte {
+	var b bytes.Buffer
+	s := New(name, input)
+	for {
+		token := s.NextToken()
+		fmt.Fprintf(&b, "%s %q %d\n", token.Kind, token.Val, token.Line)
+		if token.Kind == EOF || token.Kind == Error {
+			return b.Bytes()
+		}
+	}
+}
+
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob("../testdata/*.frb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no .frb files in ../testdata")
+	}
+
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := dumpTokens(file, string(src))
+
+		golden := strings.TrimSuffix(file, ".frb") + ".tokens"
+		if *update {
+			if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Errorf("%s (run go test -update to create it)", err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: got\n%s\nwant\n%s", file, got, want)
+		}
+	}
+}
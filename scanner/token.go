@@ -0,0 +1,152 @@
+// Copyright 2013 Carlos Brando. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package scanner
+
+import "fmt"
+
+// Token represents a token or text string returned from the scanner.
+type Token struct {
+	Kind Kind   // The kind of this token.
+	Pos  int    // The starting position, in bytes, of this token in the input string.
+	Line int    // The line number at the start of this token, starting at 1.
+	Val  string // The value of this token.
+}
+
+func (t Token) String() string {
+	switch {
+	case t.Kind == EOF:
+		return "EOF"
+	case t.Kind == Error:
+		return t.Val
+	case t.Kind.IsKeyword():
+		return fmt.Sprintf("<%s>", t.Val)
+	case len(t.Val) > 10:
+		return fmt.Sprintf("%.10q...", t.Val)
+	}
+	return fmt.Sprintf("%q", t.Val)
+}
+
+// Kind identifies the kind of tokens.
+type Kind int
+
+const (
+	Error      Kind = iota // error occurred; value is text of error
+	Char                   // printable character not part of another token; grab bag for ( ) , . etc.
+	Comment                // comment text, from '#' to the end of the line
+	Complex                // complex constant (1+2i); imaginary is just a number
+	Constant               // alphanumeric identifier starting with an upper case letter
+	EOF                    // end of the input
+	EndOfLine              // line break
+	Identifier             // alphanumeric identifier not starting with an upper case letter
+	Label                  // identifier followed by a colon, as in name: "x"
+	Number                 // simple number, including imaginary
+	Operator               // operator longer than one character, such as || or ::
+	Regexp                 // regular expression literal, /pattern/flags
+	Space                  // run of spaces separating arguments
+	String                 // string in double or single quotes (includes quotes)
+	Symbol                 // symbol literal, :name
+	// Keywords appear after all the rest.
+	keyword // used only to delimit the keywords
+	Break   // break keyword
+	Case    // case keyword
+	Def     // def keyword
+	Else    // else keyword
+	End     // end keyword
+	False   // false keyword
+	For     // for keyword
+	If      // if keyword
+	In      // in keyword
+	Module  // module keyword
+	Next    // next keyword
+	Nil     // the untyped nil constant, easiest to treat as a keyword
+	Redo    // redo keyword
+	Then    // then keyword
+	True    // true keyword
+	Unless  // unless keyword
+	When    // when keyword
+)
+
+var names = map[Kind]string{
+	Error:      "ERROR",
+	Char:       "CHAR",
+	Comment:    "COMMENT",
+	Complex:    "COMPLEX",
+	Constant:   "CONSTANT",
+	EOF:        "EOF",
+	EndOfLine:  "EOL",
+	Identifier: "IDENTIFIER",
+	Label:      "LABEL",
+	Number:     "NUMBER",
+	Operator:   "OPERATOR",
+	Regexp:     "REGEXP",
+	Space:      "SPACE",
+	String:     "STRING",
+	Symbol:     "SYMBOL",
+	Break:      "BREAK",
+	Case:       "CASE",
+	Def:        "DEF",
+	Else:       "ELSE",
+	End:        "END",
+	False:      "FALSE",
+	For:        "FOR",
+	If:         "IF",
+	In:         "IN",
+	Module:     "MODULE",
+	Next:       "NEXT",
+	Nil:        "NIL",
+	Redo:       "REDO",
+	Then:       "THEN",
+	True:       "TRUE",
+	Unless:     "UNLESS",
+	When:       "WHEN",
+}
+
+func (k Kind) String() string {
+	if name, ok := names[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// IsKeyword reports whether k is the kind of a keyword.
+func (k Kind) IsKeyword() bool {
+	return k > keyword
+}
+
+// keywords maps each keyword to its kind. It is the only keyword table;
+// everything else looks keywords up here.
+var keywords = map[string]Kind{
+	"break":  Break,
+	"case":   Case,
+	"def":    Def,
+	"else":   Else,
+	"end":    End,
+	"false":  False,
+	"for":    For,
+	"if":     If,
+	"in":     In,
+	"module": Module,
+	"next":   Next,
+	"nil":    Nil,
+	"redo":   Redo,
+	"then":   Then,
+	"true":   True,
+	"unless": Unless,
+	"when":   When,
+}
+
+// Lookup returns the kind of the keyword word, or Identifier if word is
+// not a keyword.
+func Lookup(word string) Kind {
+	if k, ok := keywords[word]; ok {
+		return k
+	}
+	return Identifier
+}
+
+// operators lists the operators longer than one character. Longer
+// operators must come before their prefixes. One character long
+// operators are scanned as Char.
+var operators = []string{"||", "&&", "===", "==", "!=", "<=", ">=", "::", "=~", "!~", "=>", "...", "..", "**"}